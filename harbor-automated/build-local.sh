@@ -7,6 +7,7 @@
 #   ./build-local.sh                    # Build with default version (1.18.0)
 #   ./build-local.sh 1.19.0             # Build with specific Harbor version
 #   ./build-local.sh 1.19.0 2           # Build with version and iteration
+#   MODIFIER_FLAGS=-resolve-digests ./build-local.sh 1.19.0 2   # Pin images by digest
 #
 
 set -e
@@ -26,30 +27,24 @@ echo "Chart Version: ${HARBOR_VERSION}-reliza.${RELIZA_ITERATION}"
 echo "=============================================="
 echo ""
 
-# Step 1: Add Harbor Helm repo (if not exists)
-echo "Step 1/4: Adding Harbor Helm repo..."
-helm repo add harbor https://helm.goharbor.io 2>/dev/null || true
-helm repo update
-echo "✅ Helm repo ready"
+# Step 1: Build and generate chart using Make
+# (harbor-modifier downloads the chart itself, no helm repo setup needed)
+echo "Step 1/3: Building and generating chart (make setup)..."
+make setup HARBOR_VERSION="$HARBOR_VERSION" MODIFIER_FLAGS="${MODIFIER_FLAGS:-}"
 echo ""
 
-# Step 2: Build and generate chart using Make
-echo "Step 2/4: Building and generating chart (make setup)..."
-make setup HARBOR_VERSION="$HARBOR_VERSION"
-echo ""
-
-# Step 3: Build chart dependencies
-echo "Step 3/4: Building chart dependencies..."
+# Step 2: Build chart dependencies
+echo "Step 2/3: Building chart dependencies..."
 cd harbor-helm
 helm dependency build
 cd ..
 echo "✅ Dependencies built"
 echo ""
 
-# Step 4: Update chart version
-echo "Step 4/4: Setting chart version..."
+# Step 3: Set chart version and package (reproducible .tgz in packages/)
+echo "Step 3/3: Setting chart version and packaging..."
 CHART_VERSION="${HARBOR_VERSION}-reliza.${RELIZA_ITERATION}"
-sed -i "s/^version:.*/version: $CHART_VERSION/" harbor-helm/Chart.yaml
+./bin/harbor-modifier package -version "$HARBOR_VERSION" -iteration "$RELIZA_ITERATION"
 echo "✅ Chart version set to: $CHART_VERSION"
 echo ""
 
@@ -68,8 +63,7 @@ echo "✅ Template renders successfully"
 
 echo ""
 echo "Verifying Reliza modifications..."
-grep -q "Reliza customization" harbor-helm/templates/_helpers.tpl && echo "✅ _helpers.tpl modified"
-grep -q "postgresql" harbor-helm/values.yaml && echo "✅ values.yaml modified"
+./bin/harbor-modifier test
 
 echo ""
 echo "=============================================="
@@ -77,6 +71,7 @@ echo "✅ BUILD COMPLETE"
 echo "=============================================="
 echo ""
 echo "Chart ready at: harbor-helm/"
+echo "Package:        packages/harbor-helm-${CHART_VERSION}.tgz"
 echo "Version: $CHART_VERSION"
 echo ""
 echo "Next steps:"
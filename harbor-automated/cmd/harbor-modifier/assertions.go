@@ -0,0 +1,283 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ChartTest is a modifications/tests/*.yaml file: values to render the
+// chart with and assertions on the rendered objects
+type ChartTest struct {
+	Values     []string               `yaml:"values"` // Values files, relative to the project directory
+	Set        map[string]interface{} `yaml:"set"`    // Inline values, merged over the files
+	Assertions []Assertion            `yaml:"assertions"`
+	file       string
+}
+
+// Assertion selects rendered objects by kind and name (glob) and checks
+// them. Without a check, it asserts that a matching object exists.
+type Assertion struct {
+	Description string      `yaml:"description"`
+	Kind        string      `yaml:"kind"`
+	Name        string      `yaml:"name"`
+	Exists      *bool       `yaml:"exists"` // false: no object may match
+	Path        string      `yaml:"path"`   // JSON pointer into the object, e.g. /data/config.yml
+	Equals      interface{} `yaml:"equals"`
+	Contains    string      `yaml:"contains"`
+	NotContains string      `yaml:"notContains"`
+	Matches     string      `yaml:"matches"` // Regular expression
+}
+
+// runTests implements `harbor-modifier test`
+func runTests(args []string) error {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	chartDir := flags.String("chart", "harbor-helm", "Chart directory to test")
+	testsDir := flags.String("tests", filepath.Join("modifications", "tests"), "Directory with test files")
+	run := flags.String("run", "", "Only run test files whose name contains this string")
+	flags.Parse(args)
+
+	fmt.Println("🧪 Running chart tests...")
+
+	tests, err := loadChartTests(*testsDir)
+	if err != nil {
+		return err
+	}
+	chart, err := loadChartDir(*chartDir)
+	if err != nil {
+		return err
+	}
+
+	ran := 0
+	var failed []string
+	for _, test := range tests {
+		name := filepath.Base(test.file)
+		if *run != "" && !strings.Contains(name, *run) {
+			continue
+		}
+		ran++
+
+		failures, err := test.run(chart, mustGetwd())
+		if err != nil {
+			failures = []string{err.Error()}
+		}
+		if len(failures) == 0 {
+			fmt.Printf("  ✅ %s (%d assertions)\n", name, len(test.Assertions))
+			continue
+		}
+
+		fmt.Printf("  ❌ %s\n", name)
+		for _, f := range failures {
+			fmt.Println(indent(f, "      "))
+		}
+		failed = append(failed, name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d test file(s) failed: %s", len(failed), ran, strings.Join(failed, ", "))
+	}
+	fmt.Printf("✅ %d test file(s) passed\n", ran)
+	return nil
+}
+
+// loadChartTests reads every *.yaml test file in dir, sorted by name
+func loadChartTests(dir string) ([]*ChartTest, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tests: %w", err)
+	}
+	sort.Strings(files)
+
+	var tests []*ChartTest
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		test := &ChartTest{file: file}
+		if err := yaml.Unmarshal(content, test); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if len(test.Assertions) == 0 {
+			return nil, fmt.Errorf("%s: no assertions", file)
+		}
+		for i, a := range test.Assertions {
+			if a.Kind == "" && a.Name == "" {
+				return nil, fmt.Errorf("%s: assertion %d needs kind or name", file, i+1)
+			}
+			if a.Matches != "" {
+				if _, err := regexp.Compile(a.Matches); err != nil {
+					return nil, fmt.Errorf("%s: assertion %d: invalid matches: %w", file, i+1, err)
+				}
+			}
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
+
+// run renders chart with the test values and returns a message per failed assertion
+func (t *ChartTest) run(chart *LoadedChart, projectDir string) ([]string, error) {
+	values := map[string]interface{}{}
+	for _, vf := range t.Values {
+		content, err := os.ReadFile(filepath.Join(projectDir, vf))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values %s: %w", vf, err)
+		}
+		fileValues, err := parseValues(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse values %s: %w", vf, err)
+		}
+		values = coalesceValues(values, fileValues)
+	}
+	if t.Set != nil {
+		values = coalesceValues(values, normalizeValue(t.Set).(map[string]interface{}))
+	}
+
+	rendered, err := NewRenderer(renderRelease, renderNamespace).Render(chart, values)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := parseManifests(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []string
+	for i, a := range t.Assertions {
+		if msg := a.check(manifests); msg != "" {
+			title := a.Description
+			if title == "" {
+				title = fmt.Sprintf("assertion %d", i+1)
+			}
+			failures = append(failures, fmt.Sprintf("✗ %s\n%s", title, indent(msg, "  ")))
+		}
+	}
+	return failures, nil
+}
+
+// check evaluates the assertion and describes the failure ("" on success)
+func (a Assertion) check(manifests []Manifest) string {
+	var matched []Manifest
+	for _, m := range manifests {
+		if a.Kind != "" && m.Kind != a.Kind {
+			continue
+		}
+		if a.Name != "" {
+			if ok, _ := path.Match(a.Name, m.Name); !ok {
+				continue
+			}
+		}
+		matched = append(matched, m)
+	}
+
+	if a.Exists != nil && !*a.Exists {
+		if len(matched) == 0 {
+			return ""
+		}
+		var found []string
+		for _, m := range matched {
+			found = append(found, fmt.Sprintf("%s/%s (%s)", m.Kind, m.Name, m.Source))
+		}
+		return fmt.Sprintf("expected no %s, found:\n  %s", a.selector(), strings.Join(found, "\n  "))
+	}
+
+	if len(matched) == 0 {
+		var available []string
+		for _, m := range manifests {
+			if a.Kind == "" || m.Kind == a.Kind {
+				available = append(available, m.Kind+"/"+m.Name)
+			}
+		}
+		msg := fmt.Sprintf("expected %s, none rendered", a.selector())
+		if len(available) > 0 && len(available) <= 20 {
+			msg += "\nrendered: " + strings.Join(available, ", ")
+		}
+		return msg
+	}
+
+	for _, m := range matched {
+		if msg := a.checkValue(m); msg != "" {
+			return fmt.Sprintf("%s/%s (%s):\n%s", m.Kind, m.Name, m.Source, msg)
+		}
+	}
+	return ""
+}
+
+// checkValue applies every value check that is set to one object
+func (a Assertion) checkValue(m Manifest) string {
+	if a.Equals == nil && a.Contains == "" && a.NotContains == "" && a.Matches == "" {
+		return ""
+	}
+
+	value, ok := lookupPointer(m.Object, a.Path)
+	if !ok {
+		return fmt.Sprintf("%s not found", a.Path)
+	}
+	text, isString := value.(string)
+	if !isString {
+		text = toYAML(value)
+	}
+
+	var failures []string
+	if a.Equals != nil {
+		expected, isString := a.Equals.(string)
+		if !isString {
+			expected = toYAML(normalizeValue(a.Equals))
+		}
+		if diff := unifiedDiff("expected", "actual", ensureNewline(expected), ensureNewline(text)); diff != "" {
+			failures = append(failures, strings.TrimSuffix(diff, "\n"))
+		}
+	}
+	if a.Contains != "" && !strings.Contains(text, a.Contains) {
+		failures = append(failures, fmt.Sprintf("expected %s to contain:\n%s\nactual:\n%s", a.pathName(), indent(a.Contains, "  | "), indent(text, "  | ")))
+	}
+	if a.NotContains != "" && strings.Contains(text, a.NotContains) {
+		failures = append(failures, fmt.Sprintf("expected %s not to contain:\n%s\nactual:\n%s", a.pathName(), indent(a.NotContains, "  | "), indent(text, "  | ")))
+	}
+	if a.Matches != "" && !regexp.MustCompile(a.Matches).MatchString(text) {
+		failures = append(failures, fmt.Sprintf("expected %s to match %q\nactual:\n%s", a.pathName(), a.Matches, indent(text, "  | ")))
+	}
+	return strings.Join(failures, "\n")
+}
+
+func (a Assertion) selector() string {
+	kind := a.Kind
+	if kind == "" {
+		kind = "object"
+	}
+	if a.Name == "" {
+		return kind
+	}
+	return fmt.Sprintf("%s named %s", kind, a.Name)
+}
+
+func (a Assertion) pathName() string {
+	if a.Path == "" {
+		return "object"
+	}
+	return a.Path
+}
+
+// indent prefixes every line of text
+func indent(text, prefix string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func ensureNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
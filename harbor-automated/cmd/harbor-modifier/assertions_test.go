@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var assertionManifests = []Manifest{
+	{Source: "harbor/templates/core/core-cm.yaml", Kind: "ConfigMap", Name: "harbor-core", Object: map[string]interface{}{
+		"kind":     "ConfigMap",
+		"metadata": map[string]interface{}{"name": "harbor-core"},
+		"data":     map[string]interface{}{"EXT_ENDPOINT": "https://harbor.example.com", "PORT": "8080"},
+	}},
+	{Source: "harbor/templates/registry/registry-cm.yaml", Kind: "ConfigMap", Name: "harbor-registry", Object: map[string]interface{}{
+		"kind":     "ConfigMap",
+		"metadata": map[string]interface{}{"name": "harbor-registry"},
+		"data":     map[string]interface{}{"config.yml": "auth:\n  token:\n    realm: https://harbor.example.com/service/token\n"},
+	}},
+}
+
+func TestAssertionCheck(t *testing.T) {
+	no := false
+	tests := []struct {
+		name      string
+		assertion Assertion
+		wantFail  string // Substring of the failure, "" when the assertion passes
+	}{
+		{"exists by kind and name", Assertion{Kind: "ConfigMap", Name: "harbor-core"}, ""},
+		{"name glob", Assertion{Name: "harbor-*"}, ""},
+		{"missing object", Assertion{Kind: "Secret", Name: "harbor-core"}, "expected Secret named harbor-core, none rendered"},
+		{"exists false", Assertion{Kind: "Secret", Exists: &no}, ""},
+		{"exists false with a match", Assertion{Name: "*-registry", Exists: &no}, "expected no object named *-registry"},
+		{"equals", Assertion{Name: "harbor-core", Path: "/data/PORT", Equals: "8080"}, ""},
+		{"equals mapping", Assertion{Name: "harbor-core", Path: "/data", Equals: map[string]interface{}{"PORT": "8080", "EXT_ENDPOINT": "https://harbor.example.com"}}, ""},
+		{"equals mismatch", Assertion{Name: "harbor-core", Path: "/data/PORT", Equals: "80"}, "+8080"},
+		{"contains", Assertion{Name: "harbor-registry", Path: "/data/config.yml", Contains: "token:"}, ""},
+		{"contains mismatch", Assertion{Name: "harbor-registry", Path: "/data/config.yml", Contains: "htpasswd:"}, "to contain"},
+		{"notContains mismatch", Assertion{Name: "harbor-registry", Path: "/data/config.yml", NotContains: "token:"}, "not to contain"},
+		{"matches", Assertion{Name: "harbor-core", Path: "/data/EXT_ENDPOINT", Matches: `^https://`}, ""},
+		{"matches mismatch", Assertion{Name: "harbor-core", Path: "/data/EXT_ENDPOINT", Matches: `^http://`}, "to match"},
+		{"missing path", Assertion{Name: "harbor-core", Path: "/data/MISSING", Contains: "x"}, "/data/MISSING not found"},
+		{"checks apply to every match", Assertion{Kind: "ConfigMap", Path: "/data/PORT", Equals: "8080"}, "harbor-registry"},
+		{"all checks pass", Assertion{Name: "harbor-registry", Path: "/data/config.yml", Contains: "token:", NotContains: "htpasswd", Matches: "realm: https"}, ""},
+		{"later check fails", Assertion{Name: "harbor-registry", Path: "/data/config.yml", Contains: "token:", NotContains: "realm"}, "not to contain"},
+		{"last check fails", Assertion{Name: "harbor-core", Path: "/data/PORT", Equals: "8080", Matches: "^9"}, "to match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.assertion.check(assertionManifests)
+			if tt.wantFail == "" {
+				if msg != "" {
+					t.Fatalf("unexpected failure:\n%s", msg)
+				}
+				return
+			}
+			if !strings.Contains(msg, tt.wantFail) {
+				t.Fatalf("expected a failure containing %q, got:\n%s", tt.wantFail, msg)
+			}
+		})
+	}
+}
+
+func TestAssertionReportsEveryFailedCheck(t *testing.T) {
+	a := Assertion{Name: "harbor-core", Path: "/data/PORT", Equals: "80", Contains: "9", Matches: "^7"}
+	msg := a.check(assertionManifests)
+	for _, want := range []string{"+8080", "to contain", "to match"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("failure does not report %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestChartTestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"chart/Chart.yaml":        "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"chart/values.yaml":       "port: 8080\nhost: harbor.local\n",
+		"chart/templates/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: harbor-core\ndata:\n  PORT: {{ .Values.port | quote }}\n  HOST: {{ .Values.host }}\n",
+		"examples/custom.yaml":    "host: harbor.example.com\n",
+		"tests/01-values.yaml":    "values: [examples/custom.yaml]\nset:\n  port: 9090\nassertions:\n  - path: /data/HOST\n    name: harbor-core\n    equals: harbor.example.com\n  - description: port from set\n    name: harbor-core\n    path: /data/PORT\n    equals: \"9090\"\n",
+		"tests/02-failing.yaml":   "assertions:\n  - description: wrong port\n    kind: ConfigMap\n    path: /data/PORT\n    equals: \"1\"\n",
+	})
+
+	tests, err := loadChartTests(filepath.Join(dir, "tests"))
+	if err != nil {
+		t.Fatalf("loadChartTests: %v", err)
+	}
+	chart, err := loadChartDir(filepath.Join(dir, "chart"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, err := tests[0].run(chart, dir)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("01-values.yaml: %v %v", err, failures)
+	}
+	failures, err = tests[1].run(chart, dir)
+	if err != nil || len(failures) != 1 || !strings.Contains(failures[0], "✗ wrong port") {
+		t.Fatalf("02-failing.yaml: expected one failure, got %v %v", err, failures)
+	}
+
+	for content, want := range map[string]string{
+		"values: []\n":                   "no assertions",
+		"assertions:\n  - path: /data\n": "needs kind or name",
+		"assertions:\n  - kind: ConfigMap\n    matches: \"(\"\n": "invalid matches",
+	} {
+		invalid := t.TempDir()
+		writeFiles(t, invalid, map[string]string{"test.yaml": content})
+		if _, err := loadChartTests(invalid); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ChartCache is a content-addressed store of upstream chart archives.
+// Online, archives are looked up by the digest the repository index (or
+// OCI manifest) lists for a version; offline, through the refs.
+//
+// Layout:
+//
+//	blobs/sha256/<hex>   chart archive, named by its SHA-256
+//	refs/<hex>.json      cacheEntry for a (source, name, version) key
+type ChartCache struct {
+	Dir string
+}
+
+// cacheEntry maps a source, chart name and version to an archive digest
+type cacheEntry struct {
+	Source    string    `json:"source"`
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Digest    string    `json:"digest"`
+	URL       string    `json:"url,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// defaultCacheDir returns the user cache directory for harbor-modifier
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "harbor-modifier")
+	}
+	return filepath.Join(dir, "harbor-modifier", "charts")
+}
+
+func (c *ChartCache) refPath(source, name, version string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + name + "\x00" + version))
+	return filepath.Join(c.Dir, "refs", hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *ChartCache) blobPath(digest string) string {
+	return filepath.Join(c.Dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// Get returns the cached archive for source, name and version. A missing
+// entry or a blob that fails verification is reported as a miss.
+func (c *ChartCache) Get(source, name, version string) ([]byte, *cacheEntry, bool) {
+	refData, err := os.ReadFile(c.refPath(source, name, version))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(refData, &entry); err != nil {
+		return nil, nil, false
+	}
+
+	data, ok := c.GetBlob(entry.Digest)
+	if !ok {
+		return nil, nil, false
+	}
+	return data, &entry, true
+}
+
+// GetBlob returns the cached archive with the given digest, if present and
+// intact
+func (c *ChartCache) GetBlob(digest string) ([]byte, bool) {
+	data, err := os.ReadFile(c.blobPath(digest))
+	if err != nil {
+		return nil, false
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.TrimPrefix(digest, "sha256:") {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores an archive and records it under source, name and version
+func (c *ChartCache) Put(source, name, version string, data []byte, cv *chartVersion) error {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	if err := writeFileAtomic(c.blobPath(digest), data); err != nil {
+		return fmt.Errorf("failed to cache chart archive: %w", err)
+	}
+
+	entry := cacheEntry{
+		Source:    source,
+		Name:      name,
+		Version:   cv.Version,
+		Digest:    digest,
+		FetchedAt: time.Now().UTC(),
+	}
+	if len(cv.URLs) > 0 {
+		entry.URL = cv.URLs[0]
+	}
+	refData, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	if err := writeFileAtomic(c.refPath(source, name, version), refData); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CachedSource serves chart archives from a ChartCache, falling back to
+// the wrapped source unless Offline is set
+type CachedSource struct {
+	Source   ChartSource
+	SourceID string // Repository URL or oci:// reference used as cache key
+	Cache    *ChartCache
+	Offline  bool
+}
+
+// Download returns the cached archive or fetches and caches it. Online, the
+// version is first resolved against the source, so a version re-published
+// under the same number is downloaded again; offline, the last archive
+// cached for the version is used ("latest" only in offline mode).
+func (s *CachedSource) Download(name, version string) ([]byte, *chartVersion, error) {
+	if s.Offline {
+		data, entry, ok := s.Cache.Get(s.SourceID, name, version)
+		if !ok {
+			return nil, nil, fmt.Errorf("chart %s %s from %s is not in the cache at %s (offline mode)", name, version, s.SourceID, s.Cache.Dir)
+		}
+		fmt.Printf("  Using cached chart %s-%s (%s)\n", entry.Name, entry.Version, entry.Digest)
+		cv := &chartVersion{Name: entry.Name, Version: entry.Version, Digest: entry.Digest}
+		if entry.URL != "" {
+			cv.URLs = []string{entry.URL}
+		}
+		return data, cv, nil
+	}
+
+	resolver, ok := s.Source.(chartResolver)
+	if !ok {
+		return s.fetch(name, version, func() ([]byte, *chartVersion, error) { return s.Source.Download(name, version) })
+	}
+
+	cv, err := resolver.Resolve(name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cv.Digest != "" {
+		digest := "sha256:" + strings.TrimPrefix(cv.Digest, "sha256:")
+		if _, entry, ok := s.Cache.Get(s.SourceID, name, cv.Version); ok && entry.Digest != digest {
+			fmt.Printf("  ⚠️  %s-%s was re-published upstream (cached %s, now %s), downloading again\n", name, cv.Version, entry.Digest, digest)
+		}
+		if data, ok := s.Cache.GetBlob(digest); ok {
+			fmt.Printf("  Using cached chart %s-%s (%s)\n", name, cv.Version, digest)
+			verified := *cv
+			verified.Digest = digest
+			return data, &verified, s.record(name, version, data, &verified)
+		}
+	}
+	return s.fetch(name, version, func() ([]byte, *chartVersion, error) { return resolver.Fetch(name, cv) })
+}
+
+// fetch downloads an archive and caches it
+func (s *CachedSource) fetch(name, version string, download func() ([]byte, *chartVersion, error)) ([]byte, *chartVersion, error) {
+	data, cv, err := download()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.record(name, version, data, cv); err != nil {
+		return nil, nil, err
+	}
+	return data, cv, nil
+}
+
+// record stores the archive under the requested version and, for "latest",
+// also under the version it resolved to
+func (s *CachedSource) record(name, version string, data []byte, cv *chartVersion) error {
+	if err := s.Cache.Put(s.SourceID, name, version, data, cv); err != nil {
+		return err
+	}
+	if cv.Version != "" && cv.Version != version {
+		return s.Cache.Put(s.SourceID, name, cv.Version, data, cv)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newCachedRepoSource(t *testing.T, repo *fakeHelmRepo, cacheDir string, offline bool) *CachedSource {
+	t.Helper()
+	src, err := newChartSource(&Config{Source: repo.URL, CacheDir: cacheDir, Offline: offline})
+	if err != nil {
+		t.Fatalf("newChartSource: %v", err)
+	}
+	return src.(*CachedSource)
+}
+
+func TestCachedSourceRevalidatesAgainstIndex(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	cacheDir := t.TempDir()
+	first := chartArchive(t, "harbor", "1.18.0", map[string]string{"values.yaml": "first: true\n"})
+	repo.publish("harbor", "1.18.0", first, "")
+	const archivePath = "/charts/harbor-1.18.0.tgz"
+
+	for i := 0; i < 2; i++ {
+		data, _, err := newCachedRepoSource(t, repo, cacheDir, false).Download("harbor", "1.18.0")
+		if err != nil {
+			t.Fatalf("Download %d: %v", i+1, err)
+		}
+		if !bytes.Equal(data, first) {
+			t.Fatalf("Download %d returned a different archive", i+1)
+		}
+	}
+	if repo.hits[archivePath] != 1 || repo.hits["/index.yaml"] != 2 {
+		t.Errorf("archive downloaded %d times, index fetched %d times; want 1 and 2", repo.hits[archivePath], repo.hits["/index.yaml"])
+	}
+
+	// Re-published under the same version: the index digest no longer
+	// matches the cached archive
+	second := chartArchive(t, "harbor", "1.18.0", map[string]string{"values.yaml": "second: true\n"})
+	repo.index = ""
+	repo.publish("harbor", "1.18.0", second, "")
+
+	data, cv, err := newCachedRepoSource(t, repo, cacheDir, false).Download("harbor", "1.18.0")
+	if err != nil {
+		t.Fatalf("Download after re-publish: %v", err)
+	}
+	if !bytes.Equal(data, second) || cv.Digest != digestOf(second) {
+		t.Fatal("re-published chart was served from the cache")
+	}
+
+	// Offline builds now use the re-published archive
+	data, _, err = newCachedRepoSource(t, repo, cacheDir, true).Download("harbor", "1.18.0")
+	if err != nil {
+		t.Fatalf("offline Download: %v", err)
+	}
+	if !bytes.Equal(data, second) {
+		t.Error("offline build did not use the latest validated archive")
+	}
+}
+
+func TestCachedSourceOffline(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	cacheDir := t.TempDir()
+	repo.publish("harbor", "1.17.0", chartArchive(t, "harbor", "1.17.0", nil), "")
+	repo.publish("harbor", "1.18.0", chartArchive(t, "harbor", "1.18.0", nil), "")
+
+	if _, _, err := newCachedRepoSource(t, repo, cacheDir, false).Download("harbor", "latest"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	repo.Close()
+
+	offline := newCachedRepoSource(t, repo, cacheDir, true)
+	for _, version := range []string{"latest", "1.18.0"} {
+		_, cv, err := offline.Download("harbor", version)
+		if err != nil {
+			t.Fatalf("offline Download %s: %v", version, err)
+		}
+		if cv.Version != "1.18.0" {
+			t.Errorf("offline %s = %s, want 1.18.0", version, cv.Version)
+		}
+	}
+
+	if _, _, err := offline.Download("harbor", "1.17.0"); err == nil || !strings.Contains(err.Error(), "not in the cache") {
+		t.Fatalf("expected a cache miss, got %v", err)
+	}
+}
+
+func TestChartCacheRejectsCorruptBlob(t *testing.T) {
+	cache := &ChartCache{Dir: t.TempDir()}
+	data := []byte("archive")
+	if err := cache.Put("https://example.com", "harbor", "1.18.0", data, &chartVersion{Version: "1.18.0"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := cache.GetBlob(digestOf(data)); !ok {
+		t.Fatal("GetBlob: miss after Put")
+	}
+
+	if err := writeFileAtomic(cache.blobPath(digestOf(data)), []byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.Get("https://example.com", "harbor", "1.18.0"); ok {
+		t.Error("corrupt blob served from the cache")
+	}
+}
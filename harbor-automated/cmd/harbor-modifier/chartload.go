@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadedChart is a chart read into memory for rendering
+type LoadedChart struct {
+	Metadata  ChartMetadata
+	Values    map[string]interface{}
+	Templates map[string]string // Path inside the chart, e.g. templates/core/core-dpl.yaml
+	Files     map[string][]byte // Other chart files, exposed as .Files
+	Subcharts []*LoadedChart
+}
+
+// ChartMetadata is Chart.yaml as seen by templates (.Chart.Name, .Chart.AppVersion...)
+type ChartMetadata struct {
+	APIVersion   string            `yaml:"apiVersion"`
+	Name         string            `yaml:"name"`
+	Version      string            `yaml:"version"`
+	AppVersion   string            `yaml:"appVersion"`
+	KubeVersion  string            `yaml:"kubeVersion"`
+	Description  string            `yaml:"description"`
+	Type         string            `yaml:"type"`
+	Home         string            `yaml:"home"`
+	Icon         string            `yaml:"icon"`
+	Keywords     []string          `yaml:"keywords"`
+	Sources      []string          `yaml:"sources"`
+	Annotations  map[string]string `yaml:"annotations"`
+	Dependencies []ChartDependency `yaml:"dependencies"`
+}
+
+// ChartDependency is an entry of Chart.yaml dependencies
+type ChartDependency struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	Repository string `yaml:"repository"`
+	Condition  string `yaml:"condition"`
+	Alias      string `yaml:"alias"`
+}
+
+// loadChartDir reads a chart directory, including packaged or unpacked
+// subcharts in charts/
+func loadChartDir(dir string) (*LoadedChart, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chart %s: %w", dir, err)
+	}
+	return loadChartFiles(files)
+}
+
+// loadChartFiles builds a chart from its files (paths relative to the chart root)
+func loadChartFiles(files map[string][]byte) (*LoadedChart, error) {
+	chartYAML, ok := files["Chart.yaml"]
+	if !ok {
+		return nil, fmt.Errorf("Chart.yaml not found")
+	}
+
+	chart := &LoadedChart{
+		Values:    map[string]interface{}{},
+		Templates: make(map[string]string),
+		Files:     make(map[string][]byte),
+	}
+	if err := yaml.Unmarshal(chartYAML, &chart.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+
+	if content, ok := files["values.yaml"]; ok {
+		values, err := parseValues(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to parse values.yaml: %w", chart.Metadata.Name, err)
+		}
+		chart.Values = values
+	}
+
+	// Subcharts are charts/<name>/... directories or charts/*.tgz archives
+	subchartDirs := make(map[string]map[string][]byte)
+	var archives []string
+	for name, content := range files {
+		switch {
+		case name == "Chart.yaml" || name == "values.yaml":
+		case strings.HasPrefix(name, "templates/"):
+			chart.Templates[name] = string(content)
+		case strings.HasPrefix(name, "charts/"):
+			rest := strings.TrimPrefix(name, "charts/")
+			if i := strings.Index(rest, "/"); i >= 0 {
+				dir := rest[:i]
+				if subchartDirs[dir] == nil {
+					subchartDirs[dir] = make(map[string][]byte)
+				}
+				subchartDirs[dir][rest[i+1:]] = content
+			} else if strings.HasSuffix(rest, ".tgz") {
+				archives = append(archives, name)
+			}
+		default:
+			chart.Files[name] = content
+		}
+	}
+
+	sort.Strings(archives)
+	for _, name := range archives {
+		subFiles, err := readChartArchive(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sub, err := loadChartFiles(subFiles)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		chart.Subcharts = append(chart.Subcharts, sub)
+	}
+
+	dirs := make([]string, 0, len(subchartDirs))
+	for dir := range subchartDirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		sub, err := loadChartFiles(subchartDirs[dir])
+		if err != nil {
+			return nil, fmt.Errorf("charts/%s: %w", dir, err)
+		}
+		chart.Subcharts = append(chart.Subcharts, sub)
+	}
+
+	return chart, nil
+}
+
+// parseValues decodes a values file the way Helm does: mappings become
+// map[string]interface{} and all numbers float64
+func parseValues(content []byte) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return normalizeValue(values).(map[string]interface{}), nil
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalizeValue(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = normalizeValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeValue(item)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+// dependencyFor returns the Chart.yaml dependency entry of a subchart
+func (c *LoadedChart) dependencyFor(sub *LoadedChart) ChartDependency {
+	for _, dep := range c.Metadata.Dependencies {
+		if dep.Name == sub.Metadata.Name {
+			return dep
+		}
+	}
+	return ChartDependency{Name: sub.Metadata.Name}
+}
+
+// valuesName is the key a subchart's values live under in the parent
+func (d ChartDependency) valuesName() string {
+	if d.Alias != "" {
+		return d.Alias
+	}
+	return d.Name
+}
+
+// coalesceValues merges src over dst (both Helm values trees) and returns
+// dst. A null in src removes the key, as with helm --set key=null.
+func coalesceValues(dst, src map[string]interface{}) map[string]interface{} {
+	out := copyValues(dst)
+	for k, v := range src {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := out[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			out[k] = coalesceValues(dstMap, srcMap)
+			continue
+		}
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValues(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyValues(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = copyValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// lookupValue returns the value at a dotted path such as "postgresql.enabled"
+func lookupValue(values map[string]interface{}, dotted string) (interface{}, bool) {
+	var current interface{} = values
+	for _, key := range strings.Split(dotted, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// isPartial reports whether a template only holds defines (_helpers.tpl)
+func isPartial(name string) bool {
+	return strings.HasPrefix(path.Base(name), "_")
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// tplDefine is the location of a {{ define }} block in a template file
+type tplDefine struct {
+	Name         string
+	Start        int // Offset of the opening "{{" of the define action
+	End          int // Offset just past the closing "}}" of the matching end action
+	NameStart    int // Offset of the quoted name in the define action
+	NameEnd      int
+	CommentStart int // Offset of a doc comment directly above the define (-1 if none)
+}
+
+// tplAction is a single {{ ... }} action in a template file
+type tplAction struct {
+	Start, End int    // Offsets of "{{" and just past "}}"
+	Body       string // Action text without delimiters and trim markers
+	Comment    bool
+}
+
+// parseTemplateFile checks that text is a valid Go template and returns the
+// names of all templates it defines
+func parseTemplateFile(name, text string) (map[string]bool, error) {
+	treeSet := make(map[string]*parse.Tree)
+	t := parse.New(name)
+	t.Mode = parse.SkipFuncCheck | parse.ParseComments
+	if _, err := t.Parse(text, "", "", treeSet); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for n := range treeSet {
+		if n != name {
+			names[n] = true
+		}
+	}
+	return names, nil
+}
+
+// scanActions splits text into its {{ ... }} actions
+func scanActions(text string) ([]tplAction, error) {
+	var actions []tplAction
+	pos := 0
+	for {
+		i := strings.Index(text[pos:], "{{")
+		if i < 0 {
+			return actions, nil
+		}
+		start := pos + i
+		j := start + 2
+		inner := strings.TrimLeft(strings.TrimPrefix(text[j:], "-"), " \t\r\n")
+
+		// Comments: {{/* ... */}} with optional trim markers
+		if strings.HasPrefix(inner, "/*") {
+			k := strings.Index(text[j:], "*/")
+			if k < 0 {
+				return nil, fmt.Errorf("unclosed comment at offset %d", start)
+			}
+			end := j + k + 2
+			rest := strings.TrimLeft(strings.TrimPrefix(strings.TrimLeft(text[end:], " \t\r\n"), "-"), " \t\r\n")
+			if !strings.HasPrefix(rest, "}}") {
+				return nil, fmt.Errorf("comment ends before closing delimiter at offset %d", start)
+			}
+			end = len(text) - len(rest) + 2
+			actions = append(actions, tplAction{Start: start, End: end, Comment: true})
+			pos = end
+			continue
+		}
+
+		// Regular action: find "}}" outside of string literals
+		end := -1
+		var quote byte
+		for k := j; k < len(text); k++ {
+			c := text[k]
+			switch {
+			case quote != 0:
+				if c == '\\' && quote != '`' {
+					k++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '`' || c == '\'':
+				quote = c
+			case strings.HasPrefix(text[k:], "}}"):
+				end = k + 2
+			}
+			if end >= 0 {
+				break
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed action at offset %d", start)
+		}
+
+		body := text[j : end-2]
+		body = strings.TrimPrefix(body, "-")
+		body = strings.TrimSuffix(body, "-")
+		actions = append(actions, tplAction{Start: start, End: end, Body: strings.TrimSpace(body)})
+		pos = end
+	}
+}
+
+// actionKeyword returns the leading keyword of an action ("define", "if", "end"...)
+func actionKeyword(body string) string {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// actionName returns the offsets and unquoted value of the template name
+// that follows keyword in action a ({{ define "name" }}, {{ block "name" . }})
+func actionName(text string, a tplAction, keyword string) (int, int, string, error) {
+	nameStart := a.Start + strings.Index(text[a.Start:a.End], keyword) + len(keyword)
+	nameStart += len(text[nameStart:a.End]) - len(strings.TrimLeft(text[nameStart:a.End], " \t\r\n"))
+	quoted, err := strconv.QuotedPrefix(text[nameStart:a.End])
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid %s name at offset %d: %w", keyword, a.Start, err)
+	}
+	name, _ := strconv.Unquote(quoted)
+	return nameStart, nameStart + len(quoted), name, nil
+}
+
+// findDefines locates every top-level define block in a template file,
+// independent of whitespace and trim markers. A name defined more than
+// once is returned once per block.
+func findDefines(name, text string) ([]tplDefine, error) {
+	actions, err := scanActions(text)
+	if err != nil {
+		if _, perr := parseTemplateFile(name, text); perr != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, perr)
+		}
+		return nil, fmt.Errorf("failed to scan %s: %w", name, err)
+	}
+
+	var defines []tplDefine
+	blocks := make(map[string]bool) // Templates defined by {{ block }} actions
+	var current *tplDefine
+	depth := 0
+	for i, a := range actions {
+		if a.Comment {
+			continue
+		}
+		switch actionKeyword(a.Body) {
+		case "define":
+			if depth == 0 {
+				nameStart, nameEnd, defName, err := actionName(text, a, "define")
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				current = &tplDefine{
+					Name:         defName,
+					Start:        a.Start,
+					NameStart:    nameStart,
+					NameEnd:      nameEnd,
+					CommentStart: -1,
+				}
+				if i > 0 && actions[i-1].Comment && strings.TrimSpace(text[actions[i-1].End:a.Start]) == "" {
+					current.CommentStart = actions[i-1].Start
+				}
+			}
+			depth++
+		case "block":
+			// A block defines a template and executes it in place
+			_, _, blockName, err := actionName(text, a, "block")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			blocks[blockName] = true
+			depth++
+		case "if", "range", "with":
+			depth++
+		case "end":
+			depth--
+			if depth == 0 && current != nil {
+				current.End = a.End
+				defines = append(defines, *current)
+				current = nil
+			}
+		}
+	}
+
+	// Cross-check the scanner against the template parser. Defining a name
+	// twice is a parse error, so repeated defines are parsed under unique names.
+	check := text
+	counts := make(map[string]int)
+	unique := make([]string, len(defines))
+	for i, d := range defines {
+		counts[d.Name]++
+		unique[i] = d.Name
+		if counts[d.Name] > 1 {
+			unique[i] = fmt.Sprintf("%s#%d", d.Name, counts[d.Name])
+		}
+	}
+	for i := len(defines) - 1; i >= 0; i-- {
+		if unique[i] != defines[i].Name {
+			check = check[:defines[i].NameStart] + strconv.Quote(unique[i]) + check[defines[i].NameEnd:]
+		}
+	}
+	names, err := parseTemplateFile(name, check)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	for _, n := range unique {
+		delete(names, n)
+	}
+	for n := range blocks {
+		delete(names, n)
+	}
+	if len(names) > 0 {
+		var missing []string
+		for n := range names {
+			missing = append(missing, n)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("could not locate define blocks in %s: %s", name, strings.Join(missing, ", "))
+	}
+
+	return defines, nil
+}
+
+// definesNamed returns the define blocks with the given name in file order
+func definesNamed(defines []tplDefine, name string) []tplDefine {
+	var matches []tplDefine
+	for _, d := range defines {
+		if d.Name == name {
+			matches = append(matches, d)
+		}
+	}
+	return matches
+}
+
+// editDefines applies edit to the first n define blocks with the given
+// name, the last one first so that the offsets of the others stay valid
+func editDefines(file, text, name string, n int, edit func(text string, d tplDefine) string) (string, error) {
+	defines, err := findDefines(file, text)
+	if err != nil {
+		return "", err
+	}
+	matches := definesNamed(defines, name)
+	if n < len(matches) {
+		matches = matches[:n]
+	}
+	for i := len(matches) - 1; i >= 0; i-- {
+		text = edit(text, matches[i])
+	}
+	return text, nil
+}
+
+// replaceDefine replaces the first n define blocks with the given name by replacement
+func replaceDefine(file, text, name, replacement string, n int) (string, error) {
+	return editDefines(file, text, name, n, func(text string, d tplDefine) string {
+		return text[:d.Start] + replacement + text[d.End:]
+	})
+}
+
+// renameDefine changes the name of the first n define blocks with the
+// given name, leaving their bodies untouched
+func renameDefine(file, text, name, newName string, n int) (string, error) {
+	return editDefines(file, text, name, n, func(text string, d tplDefine) string {
+		return text[:d.NameStart] + strconv.Quote(newName) + text[d.NameEnd:]
+	})
+}
+
+// deleteDefine removes the first n define blocks with the given name,
+// together with their doc comments and the line break that follows them
+func deleteDefine(file, text, name string, n int) (string, error) {
+	return editDefines(file, text, name, n, func(text string, d tplDefine) string {
+		start := d.Start
+		if d.CommentStart >= 0 {
+			start = d.CommentStart
+		}
+		end := d.End
+		if strings.HasPrefix(text[end:], "\r\n") {
+			end += 2
+		} else if strings.HasPrefix(text[end:], "\n") {
+			end++
+		}
+		return text[:start] + text[end:]
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const helpersWithBlock = `{{/* Chart name */}}
+{{- define "harbor.name" -}}
+{{- default .Chart.Name .Values.nameOverride | trunc 63 -}}
+{{- end -}}
+
+{{- define "harbor.labels" -}}
+app: {{ include "harbor.name" . }}
+{{- block "harbor.extraLabels" . }}
+tier: backend
+{{- end }}
+{{- end -}}
+
+{{ block "harbor.banner" . }}harbor{{ end }}
+
+{{- define "harbor.fullname" -}}
+{{- if .Values.fullnameOverride -}}
+{{ .Values.fullnameOverride }}
+{{- else -}}
+{{ printf "%s-%s" .Release.Name "harbor" }}
+{{- end -}}
+{{- end -}}
+`
+
+func defineNames(defines []tplDefine) []string {
+	var names []string
+	for _, d := range defines {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestFindDefinesWithBlocks(t *testing.T) {
+	defines, err := findDefines("_helpers.tpl", helpersWithBlock)
+	if err != nil {
+		t.Fatalf("findDefines: %v", err)
+	}
+
+	got := strings.Join(defineNames(defines), ",")
+	if want := "harbor.name,harbor.labels,harbor.fullname"; got != want {
+		t.Fatalf("defines = %s, want %s", got, want)
+	}
+
+	labels := defines[1]
+	if !strings.HasSuffix(helpersWithBlock[labels.Start:labels.End], "tier: backend\n{{- end }}\n{{- end -}}") {
+		t.Errorf("harbor.labels ends at the wrong {{ end }}: %q", helpersWithBlock[labels.Start:labels.End])
+	}
+	if defines[0].CommentStart != 0 {
+		t.Errorf("harbor.name comment start = %d, want 0", defines[0].CommentStart)
+	}
+}
+
+func TestFindDefinesRepeated(t *testing.T) {
+	text := `{{- define "a" -}}one{{- end -}}
+{{- define "b" -}}two{{- end -}}
+{{- define "a" -}}three{{- end -}}
+`
+	defines, err := findDefines("_helpers.tpl", text)
+	if err != nil {
+		t.Fatalf("findDefines: %v", err)
+	}
+	if got := len(definesNamed(defines, "a")); got != 2 {
+		t.Fatalf("found %d defines named a, want 2", got)
+	}
+}
+
+func TestFindDefinesInvalidTemplate(t *testing.T) {
+	if _, err := findDefines("_helpers.tpl", `{{- define "a" -}}{{ if .x }}{{- end -}}`); err == nil {
+		t.Fatal("expected an error for an unterminated define")
+	}
+}
+
+func TestDefineEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(text string) (string, error)
+		want string
+	}{
+		{
+			name: "replace keeps the block",
+			edit: func(text string) (string, error) {
+				return replaceDefine("_helpers.tpl", text, "harbor.name", `{{- define "harbor.name" -}}harbor{{- end -}}`, 1)
+			},
+			want: "{{/* Chart name */}}\n{{- define \"harbor.name\" -}}harbor{{- end -}}\n\n{{- define \"harbor.labels\" -}}",
+		},
+		{
+			name: "rename",
+			edit: func(text string) (string, error) {
+				return renameDefine("_helpers.tpl", text, "harbor.fullname", "harbor.upstreamFullname", 1)
+			},
+			want: `{{- define "harbor.upstreamFullname" -}}`,
+		},
+		{
+			name: "delete removes the doc comment",
+			edit: func(text string) (string, error) {
+				return deleteDefine("_helpers.tpl", text, "harbor.name", 1)
+			},
+			want: "\n{{- define \"harbor.labels\" -}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.edit(helpersWithBlock)
+			if err != nil {
+				t.Fatalf("edit: %v", err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("result does not contain %q:\n%s", tt.want, got)
+			}
+			if _, err := findDefines("_helpers.tpl", got); err != nil {
+				t.Errorf("result is not a valid template: %v", err)
+			}
+			if !strings.Contains(got, `{{ block "harbor.banner" . }}harbor{{ end }}`) {
+				t.Errorf("block was modified:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestDeleteDefineFirstN(t *testing.T) {
+	text := "{{- define \"a\" -}}one{{- end -}}\n{{- define \"a\" -}}two{{- end -}}\n"
+	got, err := deleteDefine("_helpers.tpl", text, "a", 1)
+	if err != nil {
+		t.Fatalf("deleteDefine: %v", err)
+	}
+	if got != "{{- define \"a\" -}}two{{- end -}}\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// imageDigestsReport is written to the chart root by -resolve-digests (and
+// kept out of the package by modifications/.helmignore)
+const imageDigestsReport = "IMAGE-DIGESTS.md"
+
+// pinnedImage is one row of the image digests report
+type pinnedImage struct {
+	Component string
+	Image     string
+	Digest    string
+	Source    string // values, images.lock or registry
+}
+
+// resolveComponentDigests pins every component image by digest: it resolves
+// the default image.repository:image.tag of each component referenced
+// through harbor.imageRef and writes the digest to imageDigests in the
+// merged values.yaml. It runs after the registry rewrites, so mirrored
+// images are resolved against the mirror. Digests already set in the values
+// are kept; offline, digests come from the project's images.lock.
+func resolveComponentDigests(cfg *Config) error {
+	fmt.Println("\n📌 Resolving image digests...")
+
+	components, err := imageRefComponents(cfg.ChartDir)
+	if err != nil {
+		return err
+	}
+	valuesFile := filepath.Join(cfg.ChartDir, "values.yaml")
+	doc, err := readYAMLDocument(valuesFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse values.yaml: %w", err)
+	}
+	var values map[string]interface{}
+	if err := doc.Decode(&values); err != nil {
+		return fmt.Errorf("failed to decode values.yaml: %w", err)
+	}
+	lock, err := loadImagesLock(filepath.Join(cfg.ProjectDir, imagesLockFile))
+	if err != nil {
+		return err
+	}
+	locked := make(map[string]string)
+	for _, image := range lock.Images {
+		locked[image.Repository+":"+image.Tag] = image.Digest
+	}
+
+	names := make([]string, 0, len(components))
+	for component := range components {
+		names = append(names, component)
+	}
+	sort.Strings(names)
+
+	client := newRegistryClientFromEnv(cfg.PlainHTTP)
+	var pinned []pinnedImage
+	for _, component := range names {
+		valuesPath := components[component]
+		repoValue, _ := lookupValue(values, valuesPath+".image.repository")
+		repository, _ := repoValue.(string)
+		tag, hasTag := lookupValue(values, valuesPath+".image.tag")
+		digestNode := imageDigestNode(doc.Content[0], component)
+		if digestNode == nil {
+			return fmt.Errorf("no imageDigests.%s.digest in values.yaml", component)
+		}
+
+		// reliza-cd style references carry their own tag or digest
+		if repository == "" || !hasTag || hasTagOrDigest(repository) {
+			fmt.Printf("  ⏭️  %s: %s.image is not a plain repository and tag, skipping\n", component, valuesPath)
+			continue
+		}
+		image := fmt.Sprintf("%s:%v", repository, tag)
+		entry := pinnedImage{Component: component, Image: image, Digest: digestNode.Value}
+
+		switch {
+		case entry.Digest != "":
+			entry.Source = "values"
+		case locked[image] != "":
+			entry.Digest, entry.Source = locked[image], imagesLockFile
+		case cfg.Offline:
+			return fmt.Errorf("%s: %s has no digest in %s and -offline forbids registry access", component, image, imagesLockFile)
+		default:
+			ref, err := parseImageReference(image)
+			if err != nil {
+				return err
+			}
+			entry.Digest, err = resolveImageDigest(client, ref)
+			if err != nil {
+				return fmt.Errorf("%s: %w", component, err)
+			}
+			entry.Source = "registry"
+		}
+
+		digestNode.Kind, digestNode.Tag, digestNode.Style, digestNode.Value = yaml.ScalarNode, "!!str", 0, entry.Digest
+		fmt.Printf("  ✅ %s: %s@%s (%s)\n", component, image, entry.Digest, entry.Source)
+		pinned = append(pinned, entry)
+	}
+
+	if err := writeYAMLDocument(valuesFile, doc); err != nil {
+		return fmt.Errorf("failed to write values.yaml: %w", err)
+	}
+	return writeDigestsReport(cfg.ChartDir, pinned)
+}
+
+// hasTagOrDigest reports whether an image repository value already carries a
+// tag or digest (reliza-cd style); a registry port as in localhost:5000/core
+// does not count
+func hasTagOrDigest(repository string) bool {
+	name := repository[strings.LastIndex(repository, "/")+1:]
+	return strings.ContainsAny(name, ":@")
+}
+
+// imageDigestNode returns the digest value node of imageDigests.<component>
+// (component may be a dotted path)
+func imageDigestNode(root *yaml.Node, component string) *yaml.Node {
+	node := mappingValue(root, "imageDigests")
+	for _, key := range strings.Split(component, ".") {
+		node = mappingValue(node, key)
+	}
+	return mappingValue(node, "digest")
+}
+
+// writeDigestsReport writes IMAGE-DIGESTS.md, listing the image each
+// component of this chart version is pinned to
+func writeDigestsReport(chartDir string, pinned []pinnedImage) error {
+	version, err := pulledChartVersion(chartDir)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Image Digests\n\n")
+	fmt.Fprintf(&sb, "Generated by `harbor-modifier -resolve-digests` for chart version %s.\n", version)
+	sb.WriteString("Every default image reference renders as `repository:tag@digest`.\n\n")
+	sb.WriteString("| Component | Image | Digest | Source |\n")
+	sb.WriteString("|-----------|-------|--------|--------|\n")
+	for _, p := range pinned {
+		fmt.Fprintf(&sb, "| %s | `%s` | `%s` | %s |\n", p.Component, p.Image, p.Digest, p.Source)
+	}
+
+	if err := os.WriteFile(filepath.Join(chartDir, imageDigestsReport), []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", imageDigestsReport, err)
+	}
+	fmt.Printf("✅ %d image(s) pinned, see %s\n", len(pinned), imageDigestsReport)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveComponentDigestsFromMirror(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.Username, reg.Password = "robot", "secret"
+	digest := reg.addImage("mirror/goharbor/harbor-core", "v2.14.0")
+
+	dir := t.TempDir()
+	cfg := &Config{ChartDir: filepath.Join(dir, "chart"), ProjectDir: dir, PlainHTTP: true}
+	writeFiles(t, cfg.ChartDir, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"values.yaml": "core:\n  image:\n    repository: " + reg.host() + "/mirror/goharbor/harbor-core\n    tag: v2.14.0\n" +
+			"imageDigests:\n  core:\n    digest: \"\"\n",
+		"templates/core.yaml": `image: {{ include "harbor.imageRef" (dict "repository" .Values.core.image.repository "tag" .Values.core.image.tag "digest" .Values.imageDigests.core.digest) }}` + "\n",
+	})
+
+	if err := resolveComponentDigests(cfg); err == nil || !strings.Contains(err.Error(), "failed to authenticate") {
+		t.Fatalf("expected an authentication error without credentials, got %v", err)
+	}
+
+	t.Setenv("HELM_REGISTRY_USERNAME", "robot")
+	t.Setenv("HELM_REGISTRY_PASSWORD", "secret")
+	if err := resolveComponentDigests(cfg); err != nil {
+		t.Fatalf("resolveComponentDigests: %v", err)
+	}
+
+	values, err := os.ReadFile(filepath.Join(cfg.ChartDir, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(values), "digest: "+digest) {
+		t.Errorf("digest not pinned in values.yaml:\n%s", values)
+	}
+	report, err := os.ReadFile(filepath.Join(cfg.ChartDir, imageDigestsReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), digest) || !strings.Contains(string(report), "| registry |") {
+		t.Errorf("report does not list the resolved digest:\n%s", report)
+	}
+}
+
+func TestImageRefHelper(t *testing.T) {
+	helper, err := os.ReadFile(filepath.Join("..", "..", "modifications", "helpers", "image-ref.tpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		repository, digest, want string
+	}{
+		{"goharbor/harbor-core", "", "goharbor/harbor-core:v2.14.0"},
+		{"goharbor/harbor-core", "sha256:abc", "goharbor/harbor-core:v2.14.0@sha256:abc"},
+		{"localhost:5000/goharbor/harbor-core", "sha256:abc", "localhost:5000/goharbor/harbor-core:v2.14.0@sha256:abc"},
+		{"goharbor/harbor-core:v2.15.0", "sha256:abc", "goharbor/harbor-core:v2.15.0"},
+		{"localhost:5000/goharbor/harbor-core@sha256:def", "sha256:abc", "localhost:5000/goharbor/harbor-core@sha256:def"},
+	}
+	for _, tt := range tests {
+		rendered, err := renderFixture(t, map[string]string{
+			"templates/_helpers.tpl": string(helper),
+			"templates/image.yaml":   `{{ include "harbor.imageRef" (dict "repository" .Values.repository "tag" "v2.14.0" "digest" .Values.digest) }}`,
+		}, map[string]interface{}{"repository": tt.repository, "digest": tt.digest})
+		if err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		if got := rendered["harbor/templates/image.yaml"]; got != tt.want {
+			t.Errorf("imageRef(%s, %q) = %s, want %s", tt.repository, tt.digest, got, tt.want)
+		}
+		if hasTagOrDigest(tt.repository) != (tt.want == tt.repository) {
+			t.Errorf("hasTagOrDigest(%s) disagrees with harbor.imageRef", tt.repository)
+		}
+	}
+}
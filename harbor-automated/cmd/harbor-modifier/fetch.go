@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ChartSource provides verified upstream chart archives
+type ChartSource interface {
+	Download(name, version string) ([]byte, *chartVersion, error)
+}
+
+// chartResolver is a ChartSource that can look up the digest of a chart
+// version (index entry or OCI manifest) before downloading the archive
+type chartResolver interface {
+	Resolve(name, version string) (*chartVersion, error)
+	Fetch(name string, cv *chartVersion) ([]byte, *chartVersion, error)
+}
+
+// newChartSource picks the source implementation for an HTTP(S) repository
+// URL or an oci:// reference, wrapped in the local chart cache
+func newChartSource(cfg *Config) (ChartSource, error) {
+	var src ChartSource
+	switch {
+	case strings.HasPrefix(cfg.Source, "oci://"):
+		oci, err := NewOCISource(cfg.Source, cfg.PlainHTTP)
+		if err != nil {
+			return nil, err
+		}
+		src = oci
+	case strings.HasPrefix(cfg.Source, "http://"), strings.HasPrefix(cfg.Source, "https://"):
+		src = NewRepoSource(cfg.Source)
+	default:
+		return nil, fmt.Errorf("unsupported chart source %s (expected http(s):// or oci://)", cfg.Source)
+	}
+
+	if cfg.CacheDir == "" {
+		if cfg.Offline {
+			return nil, fmt.Errorf("offline mode needs a chart cache (-cache-dir)")
+		}
+		return src, nil
+	}
+
+	return &CachedSource{
+		Source:   src,
+		SourceID: strings.TrimSuffix(cfg.Source, "/"),
+		Cache:    &ChartCache{Dir: cfg.CacheDir},
+		Offline:  cfg.Offline,
+	}, nil
+}
+
+// RepoSource downloads charts from a Helm HTTP repository without using
+// the helm CLI or its repository configuration
+type RepoSource struct {
+	RepoURL string
+	Client  *http.Client
+}
+
+// repoIndex is the subset of a Helm repository index.yaml we need
+type repoIndex struct {
+	Entries map[string][]chartVersion `yaml:"entries"`
+}
+
+type chartVersion struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version"`
+	Digest  string   `yaml:"digest"`
+	URLs    []string `yaml:"urls"`
+}
+
+// NewRepoSource creates a source for the Helm repository at repoURL
+func NewRepoSource(repoURL string) *RepoSource {
+	return &RepoSource{
+		RepoURL: strings.TrimSuffix(repoURL, "/"),
+		Client:  &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Download resolves chart name at version in the repository index and
+// returns the verified chart archive
+func (f *RepoSource) Download(name, version string) ([]byte, *chartVersion, error) {
+	cv, err := f.Resolve(name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f.Fetch(name, cv)
+}
+
+// Fetch downloads the archive of an index entry and verifies it against
+// the entry's digest
+func (f *RepoSource) Fetch(name string, cv *chartVersion) ([]byte, *chartVersion, error) {
+	if len(cv.URLs) == 0 {
+		return nil, nil, fmt.Errorf("chart %s-%s has no download URLs", name, cv.Version)
+	}
+
+	chartURL, err := f.resolveURL(cv.URLs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := f.get(chartURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download %s-%s: %w", name, cv.Version, err)
+	}
+
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+	if cv.Digest != "" && got != strings.TrimPrefix(cv.Digest, "sha256:") {
+		return nil, nil, fmt.Errorf("digest mismatch for %s-%s: index has %s, downloaded %s", name, cv.Version, cv.Digest, got)
+	}
+
+	verified := *cv
+	verified.Digest = "sha256:" + got
+	return data, &verified, nil
+}
+
+// Resolve finds the requested version in the repository index. An empty
+// version or "latest" selects the newest stable release.
+func (f *RepoSource) Resolve(name, version string) (*chartVersion, error) {
+	indexURL, err := f.resolveURL("index.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.get(indexURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repository index: %w", err)
+	}
+
+	var index repoIndex
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse repository index: %w", err)
+	}
+
+	versions := index.Entries[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("chart %s not found in %s", name, f.RepoURL)
+	}
+
+	if version == "" || version == "latest" {
+		var latest *chartVersion
+		for i := range versions {
+			v := &versions[i]
+			if strings.Contains(v.Version, "-") {
+				continue // Skip pre-releases
+			}
+			if latest == nil || compareVersions(v.Version, latest.Version) > 0 {
+				latest = v
+			}
+		}
+		if latest == nil {
+			return nil, fmt.Errorf("no stable version of %s found in %s", name, f.RepoURL)
+		}
+		return latest, nil
+	}
+
+	want := strings.TrimPrefix(version, "v")
+	for i := range versions {
+		if strings.TrimPrefix(versions[i].Version, "v") == want {
+			return &versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("chart %s version %s not found in %s", name, version, f.RepoURL)
+}
+
+// resolveURL resolves ref (absolute or relative to the repository) to a full URL
+func (f *RepoSource) resolveURL(ref string) (string, error) {
+	base, err := url.Parse(f.RepoURL + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid repository URL %s: %w", f.RepoURL, err)
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid chart URL %s: %w", ref, err)
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+func (f *RepoSource) get(u string) ([]byte, error) {
+	resp, err := f.Client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// untarChart extracts a chart archive into destDir, dropping the archive's
+// top-level chart directory
+func untarChart(data []byte, destDir string) error {
+	files, err := readChartArchive(data)
+	if err != nil {
+		return err
+	}
+
+	for name, content := range files {
+		target := filepath.Join(destDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", name, err)
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// readChartArchive returns the regular files of a chart archive keyed by
+// their slash-separated path inside the chart (the "<chart>/" prefix stripped)
+func readChartArchive(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chart archive: %w", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chart archive: %w", err)
+		}
+
+		// Refuse paths escaping the chart, then strip the "<chart>/" prefix
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return nil, fmt.Errorf("invalid path %s in chart archive", hdr.Name)
+		}
+		i := strings.Index(name, "/")
+		if i < 0 {
+			if hdr.Typeflag == tar.TypeDir {
+				continue
+			}
+			return nil, fmt.Errorf("unexpected top-level file %s in chart archive", hdr.Name)
+		}
+		name = name[i+1:]
+
+		// Charts only contain regular files; skip directories, links and other entries
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		files[name] = content
+	}
+}
+
+// compareVersions compares two dotted numeric versions (semver without
+// pre-release ordering). It returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(strings.SplitN(a, "+", 2)[0], "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(strings.SplitN(b, "+", 2)[0], "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(strings.SplitN(pa[i], "-", 2)[0])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(strings.SplitN(pb[i], "-", 2)[0])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// chartArchive builds a chart .tgz with Chart.yaml and the given extra files
+func chartArchive(t *testing.T, name, version string, files map[string]string) []byte {
+	t.Helper()
+	all := map[string]string{"Chart.yaml": fmt.Sprintf("apiVersion: v2\nname: %s\nversion: %s\n", name, version)}
+	for path, content := range files {
+		all[path] = content
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for path, content := range all {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name + "/" + path, Size: int64(len(content)), Mode: 0644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeHelmRepo serves index.yaml and chart archives like a Helm repository
+type fakeHelmRepo struct {
+	*httptest.Server
+	index  string
+	charts map[string][]byte // Path → archive
+	hits   map[string]int    // Requests by path
+}
+
+func newFakeHelmRepo(t *testing.T) *fakeHelmRepo {
+	repo := &fakeHelmRepo{charts: make(map[string][]byte), hits: make(map[string]int)}
+	repo.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo.hits[r.URL.Path]++
+		if r.URL.Path == "/index.yaml" {
+			fmt.Fprint(w, repo.index)
+			return
+		}
+		data, ok := repo.charts[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+// publish adds an archive under /charts/ and an index entry for it; digest
+// overrides the digest written to the index
+func (r *fakeHelmRepo) publish(name, version string, data []byte, digest string) {
+	path := fmt.Sprintf("/charts/%s-%s.tgz", name, version)
+	r.charts[path] = data
+	if digest == "" {
+		digest = strings.TrimPrefix(digestOf(data), "sha256:")
+	}
+	if r.index == "" {
+		r.index = "apiVersion: v1\nentries:\n  " + name + ":\n"
+	}
+	r.index += fmt.Sprintf("  - name: %s\n    version: %s\n    digest: %s\n    urls:\n    - %s\n", name, version, digest, strings.TrimPrefix(path, "/"))
+}
+
+func TestRepoSourceDownload(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	archive := chartArchive(t, "harbor", "1.18.0", map[string]string{"values.yaml": "a: 1\n"})
+	repo.publish("harbor", "1.17.0", chartArchive(t, "harbor", "1.17.0", nil), "")
+	repo.publish("harbor", "1.18.0", archive, "")
+
+	data, cv, err := NewRepoSource(repo.URL+"/").Download("harbor", "v1.18.0")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if !bytes.Equal(data, archive) {
+		t.Error("downloaded archive differs from the published one")
+	}
+	if cv.Version != "1.18.0" || cv.Digest != digestOf(archive) {
+		t.Errorf("chart version = %s %s, want 1.18.0 %s", cv.Version, cv.Digest, digestOf(archive))
+	}
+
+	files, err := readChartArchive(data)
+	if err != nil {
+		t.Fatalf("readChartArchive: %v", err)
+	}
+	if string(files["values.yaml"]) != "a: 1\n" {
+		t.Errorf("values.yaml = %q", files["values.yaml"])
+	}
+}
+
+func TestRepoSourceLatest(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	for _, version := range []string{"1.9.0", "1.18.0", "1.10.2", "1.19.0-rc.1"} {
+		repo.publish("harbor", version, chartArchive(t, "harbor", version, nil), "")
+	}
+
+	_, cv, err := NewRepoSource(repo.URL).Download("harbor", "latest")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if cv.Version != "1.18.0" {
+		t.Errorf("latest = %s, want 1.18.0 (newest stable)", cv.Version)
+	}
+}
+
+func TestRepoSourceDigestMismatch(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	repo.publish("harbor", "1.18.0", chartArchive(t, "harbor", "1.18.0", nil), strings.Repeat("0", 64))
+
+	_, _, err := NewRepoSource(repo.URL).Download("harbor", "1.18.0")
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+}
+
+func TestRepoSourceErrors(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	repo.publish("harbor", "1.18.0", chartArchive(t, "harbor", "1.18.0", nil), "")
+
+	tests := []struct {
+		name, chart, version, want string
+	}{
+		{"unknown chart", "notary", "1.0.0", "chart notary not found"},
+		{"unknown version", "harbor", "2.0.0", "version 2.0.0 not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewRepoSource(repo.URL).Download(tt.chart, tt.version)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	delete(repo.charts, "/charts/harbor-1.18.0.tgz")
+	if _, _, err := NewRepoSource(repo.URL).Download("harbor", "1.18.0"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a download error, got %v", err)
+	}
+}
+
+func TestReadChartArchiveRejectsTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../evil", Size: 1, Mode: 0644})
+	tw.Write([]byte("x"))
+	tw.Close()
+	gz.Close()
+
+	if _, err := readChartArchive(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("expected an invalid path error, got %v", err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.18.0", "1.18.0", 0},
+		{"1.18.0", "1.9.0", 1},
+		{"v1.2", "1.2.0", 0},
+		{"1.2.3", "1.2.10", -1},
+		{"1.2.3+build.1", "1.2.3", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// upstreamImageRef matches upstream image lines built from a component's
+// image values, e.g.
+//
+//	image: {{ .Values.core.image.repository }}:{{ .Values.core.image.tag }}
+//	image: "{{ .Values.nginx.image.repository }}:{{ .Values.nginx.image.tag }}"
+var upstreamImageRef = regexp.MustCompile(`(image:\s*)("?)\{\{-?\s*\.Values\.([A-Za-z0-9_.]+)\.image\.repository\s*-?\}\}:\{\{-?\s*\.Values\.([A-Za-z0-9_.]+)\.image\.tag\s*-?\}\}("?)`)
+
+// imageRefCall matches a harbor.imageRef call up to its dict arguments
+var imageRefCall = regexp.MustCompile(`include\s+"harbor\.imageRef"\s+\(dict\s`)
+
+// Arguments of a harbor.imageRef call naming the component
+var (
+	imageRefRepository = regexp.MustCompile(`"repository"\s+\.Values\.([A-Za-z0-9_.]+)\.image\.repository\b`)
+	imageRefDigest     = regexp.MustCompile(`"digest"\s+\.Values\.imageDigests\.([A-Za-z0-9_.]+)\.digest\b`)
+)
+
+// imageComponentAliases maps values paths whose imageDigests key is not
+// simply the last path element
+var imageComponentAliases = map[string]string{
+	"registry.controller": "registryctl",
+	"redis.internal":      "redis",
+}
+
+// imageComponent returns the imageDigests key for a component values path
+// such as "core" or "registry.registry"
+func imageComponent(valuesPath string) string {
+	if alias, ok := imageComponentAliases[valuesPath]; ok {
+		return alias
+	}
+	parts := strings.Split(valuesPath, ".")
+	return parts[len(parts)-1]
+}
+
+// rewriteImageRefs replaces upstream repository:tag image lines with the
+// harbor.imageRef helper and the component's imageDigests digest. It
+// returns the new content and the number of rewritten references.
+func rewriteImageRefs(content string) (string, int) {
+	count := 0
+	rewritten := upstreamImageRef.ReplaceAllStringFunc(content, func(match string) string {
+		m := upstreamImageRef.FindStringSubmatch(match)
+		prefix, openQuote, repoPath, tagPath, closeQuote := m[1], m[2], m[3], m[4], m[5]
+		if repoPath != tagPath || openQuote != closeQuote {
+			return match
+		}
+		count++
+		return fmt.Sprintf(`%s%s{{ include "harbor.imageRef" (dict "repository" .Values.%s.image.repository "tag" .Values.%s.image.tag "digest" .Values.imageDigests.%s.digest) }}%s`,
+			prefix, openQuote, repoPath, repoPath, imageComponent(repoPath), closeQuote)
+	})
+	return rewritten, count
+}
+
+// wireImageDigests adds the component's imageDigests digest to every
+// harbor.imageRef call that has none. It returns the new content, the
+// number of wired calls, the imageDigests keys referenced and the lines of
+// calls whose component is unknown.
+func wireImageDigests(content string) (string, int, []string, []int) {
+	var sb strings.Builder
+	var components []string
+	var unresolved []int
+	wired, last := 0, 0
+	for _, call := range imageRefCalls(content) {
+		args := content[call[1]:call[2]]
+
+		if m := imageRefDigest.FindStringSubmatch(args); m != nil {
+			components = append(components, m[1])
+			continue
+		}
+		if strings.Contains(args, `"digest"`) {
+			continue
+		}
+		m := imageRefRepository.FindStringSubmatch(args)
+		if m == nil {
+			unresolved = append(unresolved, strings.Count(content[:call[0]], "\n")+1)
+			continue
+		}
+
+		component := imageComponent(m[1])
+		components = append(components, component)
+		sb.WriteString(content[last:call[2]])
+		fmt.Fprintf(&sb, ` "digest" .Values.imageDigests.%s.digest`, component)
+		last = call[2]
+		wired++
+	}
+	sb.WriteString(content[last:])
+	return sb.String(), wired, components, unresolved
+}
+
+// imageRefCalls returns the start of every harbor.imageRef call in content
+// with the bounds of its dict arguments: from the opening parenthesis to
+// the closing one (exclusive)
+func imageRefCalls(content string) [][3]int {
+	var calls [][3]int
+	for _, loc := range imageRefCall.FindAllStringIndex(content, -1) {
+		open := loc[1] - len("dict ") - 1
+		if end := closingParen(content, open); end >= 0 {
+			calls = append(calls, [3]int{loc[0], open, end})
+		}
+	}
+	return calls
+}
+
+// imageRefComponents maps each imageDigests key used by the chart's image
+// references to the values path of its image, e.g. "registryctl" to
+// "registry.controller"
+func imageRefComponents(chartDir string) (map[string]string, error) {
+	components := make(map[string]string)
+	err := filepath.WalkDir(filepath.Join(chartDir, "templates"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isPartial(path) || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".tpl") {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, call := range imageRefCalls(string(content)) {
+			args := string(content[call[1]:call[2]])
+			repo := imageRefRepository.FindStringSubmatch(args)
+			digest := imageRefDigest.FindStringSubmatch(args)
+			if repo != nil && digest != nil {
+				components[digest[1]] = repo[1]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan image references: %w", err)
+	}
+	return components, nil
+}
+
+// closingParen returns the index of the parenthesis closing the one at open, or -1
+func closingParen(content string, open int) int {
+	depth := 0
+	for i := open; i < len(content); i++ {
+		switch content[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '}':
+			// Left the template action without closing the call
+			if strings.HasPrefix(content[i:], "}}") {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// applyImageRefs rewrites image references in every chart template to go
+// through harbor.imageRef (reliza-cd compatible, digest pinning) and makes
+// sure each one passes its component's imageDigests digest
+func applyImageRefs(cfg *Config) error {
+	fmt.Println("  → Rewriting image references to harbor.imageRef...")
+
+	templatesDir := filepath.Join(cfg.ChartDir, "templates")
+	total := 0
+	used := make(map[string]bool)
+	var unresolved []string
+	err := filepath.WalkDir(templatesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".tpl") {
+			return nil
+		}
+		// Partials define helpers (and document their usage), they render no images
+		if isPartial(path) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(cfg.ChartDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		rewritten, count := rewriteImageRefs(string(content))
+		rewritten, wired, components, lines := wireImageDigests(rewritten)
+		for _, component := range components {
+			used[component] = true
+		}
+		for _, line := range lines {
+			unresolved = append(unresolved, fmt.Sprintf("%s:%d", relPath, line))
+		}
+		if count == 0 && wired == 0 {
+			return nil
+		}
+		if err := os.WriteFile(path, []byte(rewritten), 0644); err != nil {
+			return err
+		}
+
+		switch {
+		case count > 0 && wired > 0:
+			fmt.Printf("    ✅ %s (%d rewritten, %d digest(s) wired)\n", relPath, count, wired)
+		case wired > 0:
+			fmt.Printf("    ✅ %s (%d digest(s) wired)\n", relPath, wired)
+		default:
+			fmt.Printf("    ✅ %s (%d)\n", relPath, count)
+		}
+		total += count + wired
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rewrite image references: %w", err)
+	}
+
+	if total == 0 {
+		fmt.Println("    ⏭️  No upstream image references to rewrite")
+	}
+	return checkImageDigests(cfg, used, unresolved)
+}
+
+// checkImageDigests compares the imageDigests entries in the merged
+// values.yaml with the components whose image references use them. A
+// referenced component without an entry breaks rendering; entries no
+// workload uses, and references without a digest, are flagged (fatal in
+// strict mode).
+func checkImageDigests(cfg *Config, used map[string]bool, unresolved []string) error {
+	content, err := os.ReadFile(filepath.Join(cfg.ChartDir, "values.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to read values.yaml: %w", err)
+	}
+	values, err := parseValues(content)
+	if err != nil {
+		return fmt.Errorf("failed to parse values.yaml: %w", err)
+	}
+	defined := make(map[string]bool)
+	if digests, ok := values["imageDigests"].(map[string]interface{}); ok {
+		collectDigestKeys(digests, "", defined)
+	}
+
+	var missing, unused []string
+	for component := range used {
+		if !defined[component] {
+			missing = append(missing, component)
+		}
+	}
+	for key := range defined {
+		if !used[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unused)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("image references use imageDigests.<component>.digest without a values entry for: %s (add them to modifications/values/image-digests.yaml)",
+			strings.Join(missing, ", "))
+	}
+	for _, key := range unused {
+		fmt.Printf("    ⚠️  imageDigests.%s: no workload uses this digest\n", key)
+	}
+	for _, location := range unresolved {
+		fmt.Printf("    ⚠️  %s: harbor.imageRef without a digest, component unknown\n", location)
+	}
+	if cfg.Strict && len(unused)+len(unresolved) > 0 {
+		return fmt.Errorf("%d imageDigests entry(ies) unused, %d image reference(s) without a digest", len(unused), len(unresolved))
+	}
+	return nil
+}
+
+// collectDigestKeys records the dotted path of every imageDigests entry
+// (a mapping with a digest key), e.g. "core" or "notary.server"
+func collectDigestKeys(values map[string]interface{}, prefix string, keys map[string]bool) {
+	for k, v := range values {
+		child, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := child["digest"]; ok {
+			keys[prefix+k] = true
+			continue
+		}
+		collectDigestKeys(child, prefix+k+".", keys)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// imagesLockFile is written by `harbor-modifier images` in the project directory
+const imagesLockFile = "images.lock"
+
+// ImagesLock is the content of images.lock: every container image the chart
+// can deploy, pinned by digest
+type ImagesLock struct {
+	Images []LockedImage `yaml:"images"`
+}
+
+// LockedImage is one image of images.lock
+type LockedImage struct {
+	Image      string `yaml:"image"` // Reference as rendered
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag,omitempty"`
+	Digest     string `yaml:"digest"`
+}
+
+// inventoryValues enables the optional workloads, so that their images are
+// collected even when no example turns them on
+func inventoryValues() map[string]interface{} {
+	return map[string]interface{}{
+		"backup": map[string]interface{}{
+			"enabled": true,
+			"skopeo":  map[string]interface{}{"enabled": true},
+		},
+		"metrics":    map[string]interface{}{"enabled": true},
+		"trivy":      map[string]interface{}{"enabled": true},
+		"postgresql": map[string]interface{}{"enabled": true},
+	}
+}
+
+// runImages implements `harbor-modifier images`: collect the images of the
+// rendered chart and pin them by digest in images.lock
+func runImages(args []string) error {
+	flags := flag.NewFlagSet("images", flag.ExitOnError)
+	chartDir := flags.String("chart", "harbor-helm", "Chart directory to render")
+	lockPath := flags.String("lock", imagesLockFile, "Lock file to write")
+	plainHTTP := flags.Bool("plain-http", false, "Use plain HTTP for registries (local registries)")
+	offline := flags.Bool("offline", false, "Do not contact registries, keep the digests already locked")
+	refresh := flags.Bool("refresh", false, "Resolve digests again, even for images already locked")
+	check := flags.Bool("check", false, "Only check that the lock file lists exactly the rendered images")
+	flags.Parse(args)
+
+	fmt.Println("📦 Collecting images from rendered manifests...")
+
+	chart, err := loadChartDir(*chartDir)
+	if err != nil {
+		return err
+	}
+	images, err := collectImages(chart, mustGetwd())
+	if err != nil {
+		return err
+	}
+	lock, err := loadImagesLock(*lockPath)
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		return checkImagesLock(lock, images, *lockPath)
+	}
+
+	locked := make(map[string]LockedImage)
+	for _, image := range lock.Images {
+		locked[image.Image] = image
+	}
+
+	client := newRegistryClientFromEnv(*plainHTTP)
+	updated := &ImagesLock{}
+	var unresolved []string
+	for _, image := range images {
+		ref, err := parseImageReference(image)
+		if err != nil {
+			return err
+		}
+		entry := LockedImage{Image: image, Repository: ref.Repository, Tag: ref.Tag, Digest: ref.Digest}
+
+		switch {
+		case entry.Digest != "":
+			fmt.Printf("  📌 %s (pinned in values)\n", image)
+		case locked[image].Digest != "" && !*refresh:
+			entry.Digest = locked[image].Digest
+			fmt.Printf("  ✅ %s\n", image)
+		case *offline:
+			fmt.Printf("  ⚠️  %s: no digest locked (offline)\n", image)
+			unresolved = append(unresolved, image)
+		default:
+			digest, err := resolveImageDigest(client, ref)
+			if err != nil {
+				fmt.Printf("  ❌ %s: %v\n", image, err)
+				unresolved = append(unresolved, image)
+				break
+			}
+			entry.Digest = digest
+			fmt.Printf("  🔍 %s → %s\n", image, digest)
+		}
+		updated.Images = append(updated.Images, entry)
+	}
+
+	if err := updated.save(*lockPath); err != nil {
+		return err
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("%d of %d image(s) have no digest in %s", len(unresolved), len(images), *lockPath)
+	}
+	fmt.Printf("✅ %d image(s) locked in %s\n", len(images), *lockPath)
+	return nil
+}
+
+// collectImages renders chart with every example, as is and with the
+// optional workloads enabled, and returns the sorted unique image fields
+func collectImages(chart *LoadedChart, projectDir string) ([]string, error) {
+	examples, err := exampleValuesFiles(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(examples) == 0 {
+		return nil, fmt.Errorf("no examples/*.yaml found")
+	}
+
+	seen := make(map[string]bool)
+	for _, example := range examples {
+		content, err := os.ReadFile(example)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", example, err)
+		}
+		values, err := parseValues(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", example, err)
+		}
+
+		for _, v := range []map[string]interface{}{values, coalesceValues(values, inventoryValues())} {
+			rendered, err := NewRenderer(renderRelease, renderNamespace).Render(chart, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Base(example), err)
+			}
+			manifests, err := parseManifests(rendered)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Base(example), err)
+			}
+			for _, m := range manifests {
+				collectImageFields(m.Object, seen)
+			}
+		}
+	}
+
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// collectImageFields records the string value of every "image" key in obj
+func collectImageFields(obj interface{}, images map[string]bool) {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if image, ok := item.(string); ok && k == "image" {
+				images[image] = true
+				continue
+			}
+			collectImageFields(item, images)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectImageFields(item, images)
+		}
+	}
+}
+
+// resolveImageDigest returns the manifest (list) digest of an image's tag
+func resolveImageDigest(client *RegistryClient, ref *imageReference) (string, error) {
+	host, repo := ref.registry()
+	digest, err := client.ManifestDigest(host, repo, ref.Tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	return digest, nil
+}
+
+// checkImagesLock reports images missing from or stale in the lock file
+func checkImagesLock(lock *ImagesLock, images []string, lockPath string) error {
+	rendered := make(map[string]bool)
+	for _, image := range images {
+		rendered[image] = true
+	}
+	locked := make(map[string]bool)
+	problems := 0
+	for _, image := range lock.Images {
+		locked[image.Image] = true
+		if !rendered[image.Image] {
+			fmt.Printf("  ❌ %s: locked but no longer rendered\n", image.Image)
+			problems++
+		} else if image.Digest == "" {
+			fmt.Printf("  ❌ %s: no digest\n", image.Image)
+			problems++
+		}
+	}
+	for _, image := range images {
+		if !locked[image] {
+			fmt.Printf("  ❌ %s: rendered but not locked\n", image)
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%s is out of date (%d problem(s)): run harbor-modifier images", lockPath, problems)
+	}
+	fmt.Printf("✅ %s lists all %d rendered image(s)\n", lockPath, len(images))
+	return nil
+}
+
+// loadImagesLock reads a lock file; a missing file is empty
+func loadImagesLock(path string) (*ImagesLock, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &ImagesLock{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var lock ImagesLock
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// save writes the lock file, sorted by image
+func (l *ImagesLock) save(path string) error {
+	sort.Slice(l.Images, func(i, j int) bool { return l.Images[i].Image < l.Images[j].Image })
+
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", path, err)
+	}
+	header := "# Container images the chart can deploy, pinned by digest.\n" +
+		"# Generated by harbor-modifier images.\n"
+	if err := os.WriteFile(path, append([]byte(header), content...), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeImagesFixture creates a project directory with a harbor-helm chart
+// whose images come from registry and an example values file. Trivy is
+// optional and only deployed by the inventory values.
+func writeImagesFixture(t *testing.T, registry string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"harbor-helm/Chart.yaml": "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"harbor-helm/values.yaml": "core:\n  image: " + registry + "/goharbor/harbor-core:v2.14.0\n" +
+			"trivy:\n  enabled: false\n  image: " + registry + "/goharbor/trivy-adapter-photon:v2.14.0\n",
+		"harbor-helm/templates/core.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: core
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: {{ .Values.core.image }}
+      containers:
+        - name: core
+          image: {{ .Values.core.image }}
+`,
+		"harbor-helm/templates/trivy.yaml": `{{- if .Values.trivy.enabled }}
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: trivy
+spec:
+  template:
+    spec:
+      containers:
+        - name: trivy
+          image: {{ .Values.trivy.image }}
+{{- end }}
+`,
+		"examples/default.yaml": "core: {}\n",
+	})
+	return dir
+}
+
+// addImage publishes an image index under repo:tag and returns its digest
+func (reg *fakeRegistry) addImage(repo, tag string) string {
+	return reg.addManifest(repo, tag, mediaTypeOCIIndex, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIIndex,
+		"manifests":     []interface{}{},
+		"annotations":   map[string]string{"image": repo + ":" + tag},
+	})
+}
+
+func TestCollectImages(t *testing.T) {
+	dir := writeImagesFixture(t, "registry.example.com")
+	chart, err := loadChartDir(filepath.Join(dir, "harbor-helm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := collectImages(chart, dir)
+	if err != nil {
+		t.Fatalf("collectImages: %v", err)
+	}
+	want := []string{
+		"registry.example.com/goharbor/harbor-core:v2.14.0",
+		"registry.example.com/goharbor/trivy-adapter-photon:v2.14.0",
+	}
+	if strings.Join(images, "\n") != strings.Join(want, "\n") {
+		t.Errorf("images = %v, want %v", images, want)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "examples", "default.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collectImages(chart, dir); err == nil {
+		t.Error("expected an error without examples")
+	}
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	reg := newFakeRegistry(t)
+	digest := reg.addImage("goharbor/harbor-core", "v2.14.0")
+	ref, err := parseImageReference(reg.host() + "/goharbor/harbor-core:v2.14.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := resolveImageDigest(NewRegistryClient(true), ref)
+	if err != nil {
+		t.Fatalf("resolveImageDigest: %v", err)
+	}
+	if got != digest {
+		t.Errorf("digest = %s, want %s", got, digest)
+	}
+	if n := reg.requests["GET /v2/goharbor/harbor-core/manifests/v2.14.0"]; n != 0 {
+		t.Errorf("manifest downloaded %d times, want a HEAD request only", n)
+	}
+
+	// Without Docker-Content-Digest the manifest is hashed instead
+	reg.OmitDigestHeader = true
+	if got, err = resolveImageDigest(NewRegistryClient(true), ref); err != nil || got != digest {
+		t.Errorf("without digest header: %s, %v; want %s", got, err, digest)
+	}
+
+	ref.Tag = "v9.9.9"
+	if _, err := resolveImageDigest(NewRegistryClient(true), ref); err == nil || !strings.Contains(err.Error(), "failed to resolve") {
+		t.Errorf("expected an error for an unknown tag, got %v", err)
+	}
+}
+
+func TestRunImagesLock(t *testing.T) {
+	reg := newFakeRegistry(t)
+	coreDigest := reg.addImage("goharbor/harbor-core", "v2.14.0")
+	trivyDigest := reg.addImage("goharbor/trivy-adapter-photon", "v2.14.0")
+	dir := writeImagesFixture(t, reg.host())
+	t.Chdir(dir)
+
+	if err := runImages([]string{"-plain-http"}); err != nil {
+		t.Fatalf("images: %v", err)
+	}
+	lock, err := loadImagesLock(imagesLockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Images) != 2 || lock.Images[0].Digest != coreDigest || lock.Images[1].Digest != trivyDigest {
+		t.Fatalf("images.lock = %+v", lock.Images)
+	}
+	if lock.Images[0].Repository != reg.host()+"/goharbor/harbor-core" || lock.Images[0].Tag != "v2.14.0" {
+		t.Errorf("core entry = %+v", lock.Images[0])
+	}
+	if err := runImages([]string{"-check"}); err != nil {
+		t.Fatalf("images -check: %v", err)
+	}
+
+	// Locked digests are reused without asking the registry again
+	manifestRequests := reg.requests["HEAD /v2/goharbor/harbor-core/manifests/v2.14.0"]
+	if err := runImages([]string{"-plain-http"}); err != nil {
+		t.Fatalf("images again: %v", err)
+	}
+	if reg.requests["HEAD /v2/goharbor/harbor-core/manifests/v2.14.0"] != manifestRequests {
+		t.Error("locked image resolved again without -refresh")
+	}
+
+	// A new image makes the lock stale; offline it cannot be resolved
+	example := "core:\n  image: " + reg.host() + "/goharbor/harbor-core:v2.15.0\n"
+	if err := os.WriteFile(filepath.Join("examples", "upgrade.yaml"), []byte(example), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runImages([]string{"-check"}); err == nil || !strings.Contains(err.Error(), "out of date") {
+		t.Fatalf("images -check: expected a stale lock error, got %v", err)
+	}
+	if err := runImages([]string{"-offline"}); err == nil || !strings.Contains(err.Error(), "1 of 3 image(s) have no digest") {
+		t.Fatalf("images -offline: expected an unresolved image, got %v", err)
+	}
+}
+
+func TestRunImagesPrivateMirror(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.Username, reg.Password = "robot", "secret"
+	reg.addImage("goharbor/harbor-core", "v2.14.0")
+	reg.addImage("goharbor/trivy-adapter-photon", "v2.14.0")
+	t.Chdir(writeImagesFixture(t, reg.host()))
+
+	if err := runImages([]string{"-plain-http"}); err == nil {
+		t.Fatal("expected unresolved images without credentials")
+	}
+
+	t.Setenv("HELM_REGISTRY_USERNAME", "robot")
+	t.Setenv("HELM_REGISTRY_PASSWORD", "secret")
+	if err := runImages([]string{"-plain-http", "-refresh"}); err != nil {
+		t.Fatalf("images with credentials: %v", err)
+	}
+}
+
+func TestCheckImagesLock(t *testing.T) {
+	images := []string{"goharbor/harbor-core:v2.14.0", "goharbor/harbor-portal:v2.14.0"}
+	tests := []struct {
+		name string
+		lock []LockedImage
+		ok   bool
+	}{
+		{"up to date", []LockedImage{{Image: images[0], Digest: "sha256:a"}, {Image: images[1], Digest: "sha256:b"}}, true},
+		{"missing image", []LockedImage{{Image: images[0], Digest: "sha256:a"}}, false},
+		{"missing digest", []LockedImage{{Image: images[0], Digest: "sha256:a"}, {Image: images[1]}}, false},
+		{"stale image", []LockedImage{{Image: images[0], Digest: "sha256:a"}, {Image: images[1], Digest: "sha256:b"}, {Image: "goharbor/notary:v2.0.0", Digest: "sha256:c"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkImagesLock(&ImagesLock{Images: tt.lock}, images, imagesLockFile)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkImagesLock = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		image              string
+		want               imageReference
+		wantHost, wantRepo string
+	}{
+		{"nginx", imageReference{Repository: "nginx", Tag: "latest"}, dockerHubHost, "library/nginx"},
+		{"goharbor/harbor-core:v2.14.0", imageReference{Repository: "goharbor/harbor-core", Tag: "v2.14.0"}, dockerHubHost, "goharbor/harbor-core"},
+		{"docker.io/library/redis:7", imageReference{Repository: "docker.io/library/redis", Tag: "7"}, dockerHubHost, "library/redis"},
+		{"localhost:5000/harbor/core", imageReference{Repository: "localhost:5000/harbor/core", Tag: "latest"}, "localhost:5000", "harbor/core"},
+		{"registry.example.com/mirror/core:v2@sha256:abc", imageReference{Repository: "registry.example.com/mirror/core", Tag: "v2", Digest: "sha256:abc"}, "registry.example.com", "mirror/core"},
+		{"ghcr.io/org/app@sha256:abc", imageReference{Repository: "ghcr.io/org/app", Digest: "sha256:abc"}, "ghcr.io", "org/app"},
+	}
+	for _, tt := range tests {
+		got, err := parseImageReference(tt.image)
+		if err != nil {
+			t.Errorf("parseImageReference(%s): %v", tt.image, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseImageReference(%s) = %+v, want %+v", tt.image, *got, tt.want)
+		}
+		if host, repo := got.registry(); host != tt.wantHost || repo != tt.wantRepo {
+			t.Errorf("registry(%s) = %s %s, want %s %s", tt.image, host, repo, tt.wantHost, tt.wantRepo)
+		}
+	}
+
+	for _, image := range []string{"", "core:v2 extra", "core@md5:abc", "registry.example.com/:v2"} {
+		if _, err := parseImageReference(image); err == nil {
+			t.Errorf("parseImageReference(%q): expected an error", image)
+		}
+	}
+}
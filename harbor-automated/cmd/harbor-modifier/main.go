@@ -16,29 +16,76 @@ import (
 
 const (
 	defaultVersion = "1.18.0"
-	defaultRepo    = "harbor"
+	defaultSource  = "https://helm.goharbor.io"
 	chartName      = "harbor"
 )
 
 type Config struct {
 	Version          string
-	RepoName         string
+	Source           string // Helm repository URL or oci:// reference
+	PlainHTTP        bool   // Use plain HTTP for oci:// sources and registries
+	CacheDir         string // Local chart cache (empty disables caching)
+	Offline          bool   // Only use the chart cache, never the network
+	FromTgz          string // Local upstream chart archive (skips download)
+	FromDir          string // Local upstream chart directory (skips download)
 	ProjectDir       string
-	ChartDir         string
+	OutputDir        string // Final chart location (harbor-helm/), replaced on success
+	ChartDir         string // Working chart directory the pipeline modifies
 	ModificationsDir string
+	Strict           bool   // Fail when a required patch does not match as expected or an overlay drifted
+	ResolveDigests   bool   // Pin imageDigests to the registry digests of the default image tags
+	ImageRegistry    string // Mirror registry prefix for every default image
+}
+
+// commands are the subcommands; without one, harbor-modifier builds the chart
+var commands = map[string]func(args []string) error{
+	"test":          runTests,
+	"snapshot":      runSnapshot,
+	"rebase":        runRebase,
+	"images":        runImages,
+	"package":       runPackage,
+	"verify":        runVerify,
+	"render":        runRender,
+	"overlay-bases": runOverlayBases,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			return
+		}
+	}
+
 	version := flag.String("version", defaultVersion, "Harbor chart version")
+	source := flag.String("source", defaultSource, "Harbor chart source: Helm repository URL or oci://host/repo/harbor[:tag|@digest]")
+	plainHTTP := flag.Bool("plain-http", false, "Use plain HTTP for oci:// sources and image registries (-resolve-digests)")
+	cacheDir := flag.String("cache-dir", defaultCacheDir(), "Upstream chart cache directory (empty disables caching)")
+	offline := flag.Bool("offline", false, "Use only cached upstream charts, fail on cache miss")
+	fromTgz := flag.String("from-tgz", "", "Use a local upstream chart archive instead of downloading")
+	fromDir := flag.String("from-dir", "", "Use a local upstream chart directory instead of downloading")
 	verbose := flag.Bool("verbose", false, "Verbose output")
+	strict := flag.Bool("strict", false, "Fail when a required patch matches zero or an unexpected number of times, or a template overlay's upstream changed")
+	resolveDigests := flag.Bool("resolve-digests", false, "Resolve each component's default image tag to its digest and pin it in imageDigests")
+	imageRegistry := flag.String("image-registry", "", "Mirror registry prefix: default images become <prefix>/<repository> (after the modifications/images.yaml rules)")
 	flag.Parse()
 
 	cfg := &Config{
 		Version:          *version,
-		RepoName:         defaultRepo,
+		Source:           *source,
+		PlainHTTP:        *plainHTTP,
+		CacheDir:         *cacheDir,
+		Offline:          *offline,
+		FromTgz:          *fromTgz,
+		FromDir:          *fromDir,
 		ProjectDir:       mustGetwd(),
-		ChartDir:         filepath.Join(mustGetwd(), "harbor-helm"),
+		OutputDir:        filepath.Join(mustGetwd(), "harbor-helm"),
 		ModificationsDir: filepath.Join(mustGetwd(), "modifications"),
+		Strict:           *strict,
+		ResolveDigests:   *resolveDigests,
+		ImageRegistry:    *imageRegistry,
 	}
 
 	if *verbose {
@@ -49,29 +96,70 @@ func main() {
 	fmt.Println("Harbor Chart Automation (Go)")
 	fmt.Println("===================================")
 	fmt.Printf("Version: %s\n", cfg.Version)
+	fmt.Printf("Source:  %s\n", cfg.sourceName())
 	fmt.Printf("Project: %s\n\n", cfg.ProjectDir)
 
+	// Build in a staging directory; harbor-helm/ is only replaced on success
+	staging, err := beginStaging(cfg.OutputDir)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	cfg.ChartDir = staging.ChartDir
+
+	fail := func(format string, args ...interface{}) {
+		staging.Abort()
+		fmt.Printf("\n⏭️  %s left unchanged\n", cfg.OutputDir)
+		log.Fatalf(format, args...)
+	}
+
 	// Step 1: Pull Harbor chart
 	if err := pullChart(cfg); err != nil {
-		log.Fatalf("❌ Failed to pull chart: %v", err)
+		fail("❌ Failed to pull chart: %v", err)
+	}
+
+	// Step 1.5: Check template overlays against the pulled upstream templates
+	if err := checkOverlayDrift(cfg); err != nil {
+		fail("❌ Template overlay check failed: %v", err)
 	}
 
 	// Step 2: Apply modifications
 	if err := applyModifications(cfg); err != nil {
-		log.Fatalf("❌ Failed to apply modifications: %v", err)
+		fail("❌ Failed to apply modifications: %v", err)
 	}
 
 	// Step 3: Validate (skip lint, just check dependencies)
 	if err := validateDependencies(cfg); err != nil {
-		log.Fatalf("❌ Validation failed: %v", err)
+		fail("❌ Validation failed: %v", err)
+	}
+
+	// Step 3.2: Rewrite default image registries (needs the subcharts in charts/)
+	if err := rewriteImageRegistries(cfg); err != nil {
+		fail("❌ Image registry rewrite failed: %v", err)
+	}
+
+	// Step 3.3: Pin imageDigests to the digests of the (mirrored) default images
+	if cfg.ResolveDigests {
+		if err := resolveComponentDigests(cfg); err != nil {
+			fail("❌ Failed to resolve image digests: %v", err)
+		}
+	}
+
+	// Step 3.5: Render the chart with examples/*.yaml (no helm binary needed)
+	if err := verifyRendering(cfg); err != nil {
+		fail("❌ Verification failed: %v", err)
+	}
+
+	// Step 4: Replace harbor-helm/ with the staged chart
+	if err := staging.Commit(); err != nil {
+		fail("❌ Failed to install chart: %v", err)
 	}
 
 	fmt.Println("\n✅ All modifications applied successfully!")
-	fmt.Printf("\nModified chart location: %s\n", cfg.ChartDir)
+	fmt.Printf("\nModified chart location: %s\n", cfg.OutputDir)
 	fmt.Println("\nNext steps:")
 	fmt.Println("  1. Review the modified chart")
 	fmt.Println("  2. Update values as needed")
-	fmt.Printf("  3. Install: helm install harbor %s -n harbor --create-namespace\n", cfg.ChartDir)
+	fmt.Printf("  3. Install: helm install harbor %s -n harbor --create-namespace\n", cfg.OutputDir)
 }
 
 func mustGetwd() string {
@@ -85,88 +173,117 @@ func mustGetwd() string {
 func pullChart(cfg *Config) error {
 	fmt.Println("📦 Pulling Harbor chart...")
 
-	// Remove existing chart
-	if err := os.RemoveAll(cfg.ChartDir); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove existing chart: %w", err)
+	if cfg.FromTgz != "" && cfg.FromDir != "" {
+		return fmt.Errorf("-from-tgz and -from-dir are mutually exclusive")
 	}
-
-	// Ensure repo is added
-	if err := ensureRepo(cfg.RepoName); err != nil {
-		return fmt.Errorf("failed to ensure repo: %w", err)
+	if cfg.FromDir != "" {
+		return copyLocalChart(cfg)
 	}
 
-	// Update repo
-	cmd := exec.Command("helm", "repo", "update", cfg.RepoName)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("helm repo update failed: %w\n%s", err, output)
-	}
+	var data []byte
+	if cfg.FromTgz != "" {
+		var err error
+		data, err = os.ReadFile(cfg.FromTgz)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", cfg.FromTgz, err)
+		}
+	} else {
+		src, err := newChartSource(cfg)
+		if err != nil {
+			return err
+		}
 
-	// Pull chart
-	cmd = exec.Command("helm", "pull",
-		fmt.Sprintf("%s/%s", cfg.RepoName, chartName),
-		"--version", cfg.Version,
-		"--untar",
-		"--untardir", cfg.ProjectDir,
-	)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("helm pull failed: %w\n%s", err, output)
+		var cv *chartVersion
+		data, cv, err = src.Download(chartName, cfg.Version)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s %s from %s: %w", chartName, cfg.Version, cfg.Source, err)
+		}
+		fmt.Printf("  Source: %s (%s-%s, %s)\n", cfg.Source, cv.Name, cv.Version, cv.Digest)
 	}
 
-	// Rename harbor -> harbor-helm
-	harborDir := filepath.Join(cfg.ProjectDir, "harbor")
-	if _, err := os.Stat(harborDir); err == nil {
-		if err := os.Rename(harborDir, cfg.ChartDir); err != nil {
-			return fmt.Errorf("failed to rename chart directory: %w", err)
-		}
+	if err := untarChart(data, cfg.ChartDir); err != nil {
+		return err
 	}
 
 	fmt.Println("✅ Harbor chart ready")
 	return nil
 }
 
-func ensureRepo(repoName string) error {
-	cmd := exec.Command("helm", "repo", "list")
-	output, err := cmd.CombinedOutput()
+// copyLocalChart replaces the chart directory with a copy of cfg.FromDir
+func copyLocalChart(cfg *Config) error {
+	src, err := filepath.Abs(cfg.FromDir)
 	if err != nil {
-		return fmt.Errorf("failed to list repos: %w", err)
+		return fmt.Errorf("failed to resolve %s: %w", cfg.FromDir, err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "Chart.yaml")); err != nil {
+		return fmt.Errorf("%s is not a chart directory (no Chart.yaml)", cfg.FromDir)
 	}
 
-	if !strings.Contains(string(output), repoName) {
-		fmt.Printf("Adding %s repo...\n", repoName)
-		cmd = exec.Command("helm", "repo", "add", repoName, "https://helm.goharbor.io")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to add repo: %w\n%s", err, output)
-		}
+	if err := copyDir(src, cfg.ChartDir); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", cfg.FromDir, err)
 	}
+
+	fmt.Println("✅ Harbor chart ready")
 	return nil
 }
 
-func applyModifications(cfg *Config) error {
-	fmt.Println("\n🔧 Applying custom modifications...")
+// copyDir recursively copies regular files and directories from src to dst
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
-	// 1. Apply helper templates
-	if err := applyHelpers(cfg); err != nil {
-		return fmt.Errorf("failed to apply helpers: %w", err)
-	}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
 
-	// 1.5. Patch database templates for postgresql support
-	if err := patchDatabaseTemplates(cfg); err != nil {
-		return fmt.Errorf("failed to patch database templates: %w", err)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+}
+
+// sourceName describes where the upstream chart comes from
+func (cfg *Config) sourceName() string {
+	switch {
+	case cfg.FromTgz != "":
+		return cfg.FromTgz + " (local archive)"
+	case cfg.FromDir != "":
+		return cfg.FromDir + " (local directory)"
+	default:
+		return cfg.Source
 	}
+}
 
-	// 1.55. Remove redundant harbor.postgresql template (harbor.database now points to it)
-	if err := removeRelizaPostgresqlTemplate(cfg); err != nil {
-		return fmt.Errorf("failed to remove harbor.postgresql template: %w", err)
+func applyModifications(cfg *Config) error {
+	fmt.Println("\n🔧 Applying custom modifications...")
+
+	// Steps already applied to this chart (with identical inputs) are skipped
+	record, err := loadRecord(cfg.ChartDir)
+	if err != nil {
+		return err
 	}
 
-	// 1.56. Patch harbor.autoGenCertForNginx to exclude Traefik
-	if err := patchAutoGenCertForNginx(cfg); err != nil {
-		return fmt.Errorf("failed to patch harbor.autoGenCertForNginx: %w", err)
+	// 1. Apply helper templates
+	if err := record.apply(cfg, "helpers", applyHelpers); err != nil {
+		return fmt.Errorf("failed to apply helpers: %w", err)
 	}
 
-	// 1.57. Patch registry templates for token authentication
-	if err := patchRegistryTemplates(cfg); err != nil {
-		return fmt.Errorf("failed to patch registry templates: %w", err)
+	// 1.5. Apply patches from modifications/patches/
+	if err := record.apply(cfg, "patches", applyPatches); err != nil {
+		return fmt.Errorf("failed to apply patches: %w", err)
 	}
 
 	// 1.6. Remove harbor-db templates (replaced by postgresql)
@@ -175,35 +292,45 @@ func applyModifications(cfg *Config) error {
 	}
 
 	// 2. Apply templates
-	if err := applyTemplates(cfg); err != nil {
+	if err := record.apply(cfg, "templates", applyTemplates); err != nil {
 		return fmt.Errorf("failed to apply templates: %w", err)
 	}
 
 	// 3. Merge values
-	if err := mergeValues(cfg); err != nil {
+	if err := record.apply(cfg, "values", mergeValues); err != nil {
 		return fmt.Errorf("failed to merge values: %w", err)
 	}
 
-	// 3.5. Clean up obsolete database.internal section
-	if err := cleanupDatabaseInternal(cfg); err != nil {
-		return fmt.Errorf("failed to cleanup database.internal: %w", err)
-	}
-
 	// 4. Update Chart.yaml
-	if err := updateChart(cfg); err != nil {
+	if err := record.apply(cfg, "chart", updateChart); err != nil {
 		return fmt.Errorf("failed to update Chart.yaml: %w", err)
 	}
 
+	// 4.5. Generate values.schema.json from the merged values
+	if err := generateValuesSchema(cfg); err != nil {
+		return fmt.Errorf("failed to generate values.schema.json: %w", err)
+	}
+
 	// 5. Update .helmignore
-	if err := updateHelmignore(cfg); err != nil {
+	if err := record.apply(cfg, ".helmignore", updateHelmignore); err != nil {
 		return fmt.Errorf("failed to update .helmignore: %w", err)
 	}
 
-	// 6. Apply template overlays (replaces image patching)
-	if err := applyTemplateOverlays(cfg); err != nil {
+	// 6. Apply template overlays
+	if err := record.apply(cfg, "template-overlays", applyTemplateOverlays); err != nil {
 		return fmt.Errorf("failed to apply template overlays: %w", err)
 	}
 
+	// 6.5. Rewrite upstream image references to harbor.imageRef with digests
+	if err := applyImageRefs(cfg); err != nil {
+		return err
+	}
+
+	// 7. Record applied modifications
+	if err := record.save(cfg.ChartDir); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -265,296 +392,6 @@ func removeHarborDatabase(cfg *Config) error {
 	return nil
 }
 
-func patchDatabaseTemplates(cfg *Config) error {
-	fmt.Println("  → Patching database templates for postgresql...")
-
-	targetFile := filepath.Join(cfg.ChartDir, "templates", "_helpers.tpl")
-
-	content, err := os.ReadFile(targetFile)
-	if err != nil {
-		return fmt.Errorf("failed to read _helpers.tpl: %w", err)
-	}
-
-	newContent := string(content)
-
-	// 1. Replace harbor.database definition to point to postgresql service
-	oldDatabase := `{{- define "harbor.database" -}}
-  {{- printf "%s-database" (include "harbor.fullname" .) -}}
-{{- end -}}`
-
-	newDatabase := `{{- define "harbor.database" -}}
-  {{- printf "%s-postgresql" (include "harbor.fullname" .) -}}
-{{- end -}}`
-
-	newContent = strings.Replace(newContent, oldDatabase, newDatabase, 1)
-
-	// 2. Update harbor.database.username to use postgresql.auth.username
-	oldUsername := `{{- define "harbor.database.username" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- printf "%s" "postgres" -}}
-  {{- else -}}
-    {{- .Values.database.external.username -}}
-  {{- end -}}
-{{- end -}}`
-
-	newUsername := `{{- define "harbor.database.username" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- .Values.postgresql.auth.username -}}
-  {{- else -}}
-    {{- .Values.database.external.username -}}
-  {{- end -}}
-{{- end -}}`
-
-	newContent = strings.Replace(newContent, oldUsername, newUsername, 1)
-
-	// 3. Update harbor.database.rawPassword to use postgresql.auth.password (remove secret lookup)
-	// Also respects existingSecret - returns empty when secret is external
-	oldPassword := `{{- define "harbor.database.rawPassword" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- $existingSecret := lookup "v1" "Secret" .Release.Namespace (include "harbor.database" .) -}}
-    {{- if and (not (empty $existingSecret)) (hasKey $existingSecret.data "POSTGRES_PASSWORD") -}}
-      {{- .Values.database.internal.password | default (index $existingSecret.data "POSTGRES_PASSWORD" | b64dec) -}}
-    {{- else -}}
-      {{- .Values.database.internal.password -}}
-    {{- end -}}
-  {{- else -}}
-    {{- .Values.database.external.password -}}
-  {{- end -}}
-{{- end -}}`
-
-	newPassword := `{{- define "harbor.database.rawPassword" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- if not .Values.postgresql.auth.existingSecret -}}
-      {{- .Values.postgresql.auth.password -}}
-    {{- end -}}
-  {{- else -}}
-    {{- .Values.database.external.password -}}
-  {{- end -}}
-{{- end -}}
-
-{{- define "harbor.database.existingSecretName" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- .Values.postgresql.auth.existingSecret -}}
-  {{- else -}}
-    {{- .Values.database.external.existingSecret -}}
-  {{- end -}}
-{{- end -}}
-
-{{- define "harbor.database.existingSecretPasswordKey" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- $user := .Values.postgresql.auth.username -}}
-    {{- if or (empty $user) (eq $user "postgres") -}}
-      {{- coalesce .Values.postgresql.auth.secretKeys.adminPasswordKey "postgres-password" -}}
-    {{- else -}}
-      {{- coalesce .Values.postgresql.auth.secretKeys.userPasswordKey "password" -}}
-    {{- end -}}
-  {{- else -}}
-    {{- "password" -}}
-  {{- end -}}
-{{- end -}}`
-
-	newContent = strings.Replace(newContent, oldPassword, newPassword, 1)
-
-	// 4. Update harbor.database.coreDatabase to use postgresql.auth.database
-	oldCoreDatabase := `{{- define "harbor.database.coreDatabase" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- printf "%s" "registry" -}}
-  {{- else -}}
-    {{- .Values.database.external.coreDatabase -}}
-  {{- end -}}
-{{- end -}}`
-
-	newCoreDatabase := `{{- define "harbor.database.coreDatabase" -}}
-  {{- if eq .Values.database.type "internal" -}}
-    {{- .Values.postgresql.auth.database -}}
-  {{- else -}}
-    {{- .Values.database.external.coreDatabase -}}
-  {{- end -}}
-{{- end -}}`
-
-	newContent = strings.Replace(newContent, oldCoreDatabase, newCoreDatabase, 1)
-
-	if err := os.WriteFile(targetFile, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write patched _helpers.tpl: %w", err)
-	}
-
-	fmt.Println("    ✅ Database templates patched (6 templates updated)")
-	return nil
-}
-
-func removeRelizaPostgresqlTemplate(cfg *Config) error {
-	fmt.Println("  → Removing redundant harbor.postgresql template...")
-
-	targetFile := filepath.Join(cfg.ChartDir, "templates", "_helpers.tpl")
-
-	content, err := os.ReadFile(targetFile)
-	if err != nil {
-		return fmt.Errorf("failed to read _helpers.tpl: %w", err)
-	}
-
-	// Remove the harbor.postgresql template definition (added by applyHelpers)
-	templateToRemove := `{{/*
-Reliza PostgreSQL service name
-Returns the service name for postgresql when enabled
-*/}}
-{{- define "harbor.postgresql" -}}
-  {{- printf "%s-postgresql" (include "harbor.fullname" .) -}}
-{{- end -}}
-`
-
-	newContent := strings.Replace(string(content), templateToRemove, "", 1)
-
-	if newContent == string(content) {
-		fmt.Println("    ⏭️  harbor.postgresql template not found (already removed or not added)")
-		return nil
-	}
-
-	if err := os.WriteFile(targetFile, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write _helpers.tpl: %w", err)
-	}
-
-	fmt.Println("    ✅ Redundant template removed")
-	return nil
-}
-
-func patchAutoGenCertForNginx(cfg *Config) error {
-	fmt.Println("  → Patching harbor.autoGenCertForNginx to exclude Traefik...")
-
-	targetFile := filepath.Join(cfg.ChartDir, "templates", "_helpers.tpl")
-
-	content, err := os.ReadFile(targetFile)
-	if err != nil {
-		return fmt.Errorf("failed to read _helpers.tpl: %w", err)
-	}
-
-	// Replace the original harbor.autoGenCertForNginx template
-	oldTemplate := `{{- define "harbor.autoGenCertForNginx" -}}
-  {{- if and (eq (include "harbor.autoGenCert" .) "true") (ne .Values.expose.type "ingress") -}}
-    {{- printf "true" -}}
-  {{- else -}}
-    {{- printf "false" -}}
-  {{- end -}}
-{{- end -}}`
-
-	newTemplate := `{{- define "harbor.autoGenCertForNginx" -}}
-  {{- if and (eq (include "harbor.autoGenCert" .) "true") (ne .Values.expose.type "ingress") (ne .Values.expose.type "traefik") -}}
-    {{- printf "true" -}}
-  {{- else -}}
-    {{- printf "false" -}}
-  {{- end -}}
-{{- end -}}`
-
-	newContent := strings.Replace(string(content), oldTemplate, newTemplate, 1)
-
-	if newContent == string(content) {
-		fmt.Println("    ⏭️  harbor.autoGenCertForNginx template not found or already patched")
-		return nil
-	}
-
-	if err := os.WriteFile(targetFile, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write _helpers.tpl: %w", err)
-	}
-
-	fmt.Println("    ✅ harbor.autoGenCertForNginx patched to exclude Traefik")
-	return nil
-}
-
-func patchRegistryTemplates(cfg *Config) error {
-	fmt.Println("  → Patching registry templates for token authentication...")
-
-	patchCount := 0
-
-	// 1. Patch registry configmap to use token auth when TLS is enabled
-	registryCmPath := filepath.Join(cfg.ChartDir, "templates", "registry", "registry-cm.yaml")
-	content, err := os.ReadFile(registryCmPath)
-	if err != nil {
-		return fmt.Errorf("failed to read registry-cm.yaml: %w", err)
-	}
-
-	oldAuth := `    auth:
-      htpasswd:
-        realm: harbor-registry-basic-realm
-        path: /etc/registry/passwd`
-
-	newAuth := `    auth:
-      {{- if .Values.expose.tls.enabled }}
-      token:
-        realm: {{ .Values.externalURL }}/service/token
-        service: harbor-registry
-        issuer: harbor-token-issuer
-        rootcertbundle: /etc/registry/root.crt
-      {{- else }}
-      htpasswd:
-        realm: harbor-registry-basic-realm
-        path: /etc/registry/passwd
-      {{- end }}`
-
-	newContent := strings.Replace(string(content), oldAuth, newAuth, 1)
-	if newContent != string(content) {
-		if err := os.WriteFile(registryCmPath, []byte(newContent), 0644); err != nil {
-			return fmt.Errorf("failed to write registry-cm.yaml: %w", err)
-		}
-		patchCount++
-	}
-
-	// 2. Add token certificate volume mount to registry deployment
-	registryDplPath := filepath.Join(cfg.ChartDir, "templates", "registry", "registry-dpl.yaml")
-	content, err = os.ReadFile(registryDplPath)
-	if err != nil {
-		return fmt.Errorf("failed to read registry-dpl.yaml: %w", err)
-	}
-
-	// Add volume mount after registry-config mount
-	oldVolumeMount := `        - name: registry-config
-          mountPath: /etc/registry/config.yml
-          subPath: config.yml`
-
-	newVolumeMount := `        - name: registry-config
-          mountPath: /etc/registry/config.yml
-          subPath: config.yml
-        {{- if .Values.expose.tls.enabled }}
-        - name: token-cert
-          mountPath: /etc/registry/root.crt
-          subPath: tls.crt
-        {{- end }}`
-
-	newContent = strings.Replace(string(content), oldVolumeMount, newVolumeMount, 1)
-	if newContent != string(content) {
-		content = []byte(newContent)
-		patchCount++
-	}
-
-	// Add volume definition after registry-config volume
-	oldVolume := `      - name: registry-config
-        configMap:
-          name: "{{ template "harbor.registry" . }}"`
-
-	newVolume := `      - name: registry-config
-        configMap:
-          name: "{{ template "harbor.registry" . }}"
-      {{- if .Values.expose.tls.enabled }}
-      - name: token-cert
-        secret:
-          secretName: {{ template "harbor.core" . }}
-      {{- end }}`
-
-	newContent = strings.Replace(string(content), oldVolume, newVolume, 1)
-	if newContent != string(content) {
-		if err := os.WriteFile(registryDplPath, []byte(newContent), 0644); err != nil {
-			return fmt.Errorf("failed to write registry-dpl.yaml: %w", err)
-		}
-		patchCount++
-	}
-
-	if patchCount > 0 {
-		fmt.Printf("    ✅ Registry templates patched (%d changes)\n", patchCount)
-	} else {
-		fmt.Println("    ⏭️  Registry templates already patched or not found")
-	}
-
-	return nil
-}
-
 func applyTemplates(cfg *Config) error {
 	fmt.Println("  → Adding custom templates...")
 
@@ -590,75 +427,20 @@ func mergeValues(cfg *Config) error {
 	valuesDir := filepath.Join(cfg.ModificationsDir, "values")
 	targetFile := filepath.Join(cfg.ChartDir, "values.yaml")
 
-	// Read existing values
-	existing, err := os.ReadFile(targetFile)
+	// Read existing values (as a node tree to keep upstream comments and key order)
+	doc, err := readYAMLDocument(targetFile)
 	if err != nil {
-		return fmt.Errorf("failed to read values.yaml: %w", err)
-	}
-
-	var existingValues map[string]interface{}
-	if err := yaml.Unmarshal(existing, &existingValues); err != nil {
 		return fmt.Errorf("failed to parse values.yaml: %w", err)
 	}
-
-	// Check if already modified
-	if _, ok := existingValues["postgresql"]; ok {
-		fmt.Println("    ⏭️  Values already merged, skipping...")
-		return nil
-	}
+	existingValues := doc.Content[0]
 
 	// Read and merge all value files
-	valueFiles, err := filepath.Glob(filepath.Join(valuesDir, "*.yaml"))
-	if err != nil {
-		return fmt.Errorf("failed to glob values: %w", err)
-	}
-
-	for _, vf := range valueFiles {
-		content, err := os.ReadFile(vf)
-		if err != nil {
-			return fmt.Errorf("failed to read %s: %w", vf, err)
-		}
-
-		var newValues map[string]interface{}
-		if err := yaml.Unmarshal(content, &newValues); err != nil {
-			return fmt.Errorf("failed to parse %s: %w", vf, err)
-		}
-
-		// Merge
-		mergeMaps(existingValues, newValues)
-	}
-
-	// Add traefik config under expose section
-	if expose, ok := existingValues["expose"].(map[string]interface{}); ok {
-		if _, ok := expose["traefik"]; !ok {
-			expose["traefik"] = map[string]interface{}{
-				"apiVersion":  "traefik.io/v1alpha1",
-				"enabled":     false,
-				"host":        "harbor.example.com",
-				"middlewares": []interface{}{},
-				"tls": map[string]interface{}{
-					"enabled":      true,
-					"certResolver": "",
-					"secretName":   "",
-				},
-				"httpsRedirect": map[string]interface{}{
-					"enabled": true,
-				},
-				"ipWhitelist": map[string]interface{}{
-					"enabled":     false,
-					"sourceRange": []interface{}{},
-				},
-			}
-		}
+	if err := mergeOverlayFiles(existingValues, valuesDir); err != nil {
+		return err
 	}
 
 	// Write merged values
-	merged, err := yaml.Marshal(existingValues)
-	if err != nil {
-		return fmt.Errorf("failed to marshal values: %w", err)
-	}
-
-	if err := os.WriteFile(targetFile, merged, 0644); err != nil {
+	if err := writeYAMLDocument(targetFile, doc); err != nil {
 		return fmt.Errorf("failed to write values.yaml: %w", err)
 	}
 
@@ -666,45 +448,6 @@ func mergeValues(cfg *Config) error {
 	return nil
 }
 
-func cleanupDatabaseInternal(cfg *Config) error {
-	fmt.Println("  → Cleaning up obsolete database.internal section...")
-
-	targetFile := filepath.Join(cfg.ChartDir, "values.yaml")
-
-	// Read values
-	content, err := os.ReadFile(targetFile)
-	if err != nil {
-		return fmt.Errorf("failed to read values.yaml: %w", err)
-	}
-
-	var values map[string]interface{}
-	if err := yaml.Unmarshal(content, &values); err != nil {
-		return fmt.Errorf("failed to parse values.yaml: %w", err)
-	}
-
-	// Remove database.internal section
-	if database, ok := values["database"].(map[string]interface{}); ok {
-		if _, exists := database["internal"]; exists {
-			delete(database, "internal")
-			fmt.Println("    ✅ Removed database.internal section")
-		} else {
-			fmt.Println("    ⏭️  database.internal not found (already removed)")
-		}
-	}
-
-	// Write updated values
-	updated, err := yaml.Marshal(values)
-	if err != nil {
-		return fmt.Errorf("failed to marshal values: %w", err)
-	}
-
-	if err := os.WriteFile(targetFile, updated, 0644); err != nil {
-		return fmt.Errorf("failed to write values.yaml: %w", err)
-	}
-
-	return nil
-}
-
 func mergeMaps(dst, src map[string]interface{}) {
 	for k, v := range src {
 		if dstVal, ok := dst[k]; ok {
@@ -866,6 +609,9 @@ func applyTemplateOverlays(cfg *Config) error {
 		if err != nil {
 			return fmt.Errorf("failed to read overlay %s: %w", relPath, err)
 		}
+		if hasConflictMarkers(string(content)) {
+			return fmt.Errorf("overlay %s has unresolved rebase conflicts", relPath)
+		}
 
 		if err := os.WriteFile(targetPath, content, 0644); err != nil {
 			return fmt.Errorf("failed to write %s: %w", relPath, err)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var upstreamFixture = map[string]string{
+	"Chart.yaml":             "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+	"values.yaml":            "core:\n  replicas: 1\n",
+	"templates/_helpers.tpl": "{{- define \"harbor.name\" -}}harbor{{- end -}}\n",
+	"templates/core/cm.yaml": "kind: ConfigMap\n",
+}
+
+// checkPulledChart compares the chart directory with upstreamFixture
+func checkPulledChart(t *testing.T, chartDir string) {
+	t.Helper()
+	for path, want := range upstreamFixture {
+		got, err := os.ReadFile(filepath.Join(chartDir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Errorf("%s not pulled: %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if version, err := pulledChartVersion(chartDir); err != nil || version != "1.18.0" {
+		t.Errorf("pulled version = %s %v, want 1.18.0", version, err)
+	}
+}
+
+func TestPullChartFromTgz(t *testing.T) {
+	// helm package names the top directory after the chart, other tools
+	// (GitHub release tarballs, manual tar) do not
+	for _, top := range []string{"harbor", "harbor-helm-1.18.0"} {
+		t.Run(top, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "harbor-1.18.0.tgz")
+			if err := os.WriteFile(archive, chartArchive(t, top, "1.18.0", upstreamFixture), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &Config{FromTgz: archive, ChartDir: filepath.Join(dir, "chart")}
+			if err := pullChart(cfg); err != nil {
+				t.Fatalf("pullChart: %v", err)
+			}
+			checkPulledChart(t, cfg.ChartDir)
+			if _, err := os.Stat(filepath.Join(cfg.ChartDir, top)); !os.IsNotExist(err) {
+				t.Errorf("top directory %s/ not stripped", top)
+			}
+		})
+	}
+}
+
+func TestPullChartFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, filepath.Join(dir, "upstream"), upstreamFixture)
+
+	cfg := &Config{FromDir: filepath.Join(dir, "upstream"), ChartDir: filepath.Join(dir, "chart")}
+	if err := pullChart(cfg); err != nil {
+		t.Fatalf("pullChart: %v", err)
+	}
+	checkPulledChart(t, cfg.ChartDir)
+
+	// The build works on a copy, never on the source directory
+	if err := os.WriteFile(filepath.Join(cfg.ChartDir, "values.yaml"), []byte("changed: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "upstream", "values.yaml")); string(content) != upstreamFixture["values.yaml"] {
+		t.Errorf("source directory modified: %q", content)
+	}
+}
+
+func TestPullChartLocalErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"not-a-chart/values.yaml": "a: 1\n",
+		"broken.tgz":              "not gzip",
+	})
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		want string
+	}{
+		{"both sources", &Config{FromTgz: filepath.Join(dir, "broken.tgz"), FromDir: filepath.Join(dir, "not-a-chart")}, "mutually exclusive"},
+		{"directory without Chart.yaml", &Config{FromDir: filepath.Join(dir, "not-a-chart")}, "is not a chart directory (no Chart.yaml)"},
+		{"missing archive", &Config{FromTgz: filepath.Join(dir, "missing.tgz")}, "failed to read"},
+		{"invalid archive", &Config{FromTgz: filepath.Join(dir, "broken.tgz")}, "failed to open chart archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.ChartDir = filepath.Join(t.TempDir(), "chart")
+			if err := pullChart(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is one Kubernetes object from the rendered chart
+type Manifest struct {
+	Source string // Template that produced it
+	Kind   string
+	Name   string
+	Object map[string]interface{}
+}
+
+// parseManifests splits rendered templates into Kubernetes objects, in
+// template name order. NOTES.txt and empty documents are skipped.
+func parseManifests(rendered map[string]string) ([]Manifest, error) {
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		if !strings.HasSuffix(name, "NOTES.txt") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var manifests []Manifest
+	for _, name := range names {
+		dec := yaml.NewDecoder(bytes.NewReader([]byte(rendered[name])))
+		for {
+			var obj map[string]interface{}
+			err := dec.Decode(&obj)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: rendered output is not valid YAML: %w", name, err)
+			}
+			if len(obj) == 0 {
+				continue
+			}
+
+			m := Manifest{Source: name, Object: obj}
+			m.Kind, _ = obj["kind"].(string)
+			if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+				m.Name, _ = metadata["name"].(string)
+			}
+			manifests = append(manifests, m)
+		}
+	}
+	return manifests, nil
+}
+
+// lookupPointer resolves a JSON pointer such as /data/config.yml or
+// /spec/template/spec/containers/0/image in an object
+func lookupPointer(obj interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" || pointer == "/" {
+		return obj, true
+	}
+	current := obj
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch c := current.(type) {
+		case map[string]interface{}:
+			v, ok := c[token]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			current = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// imageRewritesFile (in modifications/) holds the registry prefix rewrites
+// for mirrored images
+const imageRewritesFile = "images.yaml"
+
+// ImageRewrites is the content of modifications/images.yaml
+type ImageRewrites struct {
+	Rewrites []ImageRewrite `yaml:"rewrites"`
+}
+
+// ImageRewrite replaces the From prefix of image repositories with To.
+// Prefixes are matched against the repository including its registry
+// (goharbor/ is docker.io/goharbor/); an empty From matches the registry of
+// any image.
+type ImageRewrite struct {
+	From   string   `yaml:"from"`
+	To     string   `yaml:"to"`
+	Except []string `yaml:"except"` // Values paths of images to leave alone, e.g. trivy, postgresql.metrics
+}
+
+// imageValue is an image in the chart's default values
+type imageValue struct {
+	Path       string // Values path of the mapping holding "image", e.g. core or backup.skopeo
+	Registry   string // image.registry (Bitnami style images), else ""
+	Repository string // image.repository, or the image string without tag and digest
+	Suffix     string // Tag and digest of an image string
+	Scalar     bool   // image is a "repository[:tag][@digest]" string
+}
+
+// loadImageRewrites reads modifications/images.yaml and adds the
+// -image-registry rule last; a missing file has no rules
+func loadImageRewrites(cfg *Config) ([]ImageRewrite, error) {
+	var rewrites ImageRewrites
+	path := filepath.Join(cfg.ModificationsDir, imageRewritesFile)
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", imageRewritesFile, err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(content, &rewrites); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", imageRewritesFile, err)
+		}
+	}
+	for i, rule := range rewrites.Rewrites {
+		if rule.To == "" {
+			return nil, fmt.Errorf("%s: rewrite %d has no to", imageRewritesFile, i+1)
+		}
+	}
+
+	if cfg.ImageRegistry != "" {
+		rewrites.Rewrites = append(rewrites.Rewrites, ImageRewrite{To: cfg.ImageRegistry})
+	}
+	return rewrites.Rewrites, nil
+}
+
+// rewriteImageRegistries applies the registry prefix rewrites to every
+// image in the default values: component image.repository keys, image
+// strings such as backup.image, and the subcharts' default images (written
+// as overrides under the subchart's key)
+func rewriteImageRegistries(cfg *Config) error {
+	fmt.Println("\n🪞 Rewriting image registries...")
+
+	rules, err := loadImageRewrites(cfg)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fmt.Printf("  ⏭️  No -image-registry or modifications/%s rules, skipping...\n", imageRewritesFile)
+		return nil
+	}
+
+	chart, err := loadChartDir(cfg.ChartDir)
+	if err != nil {
+		return err
+	}
+	if len(chart.Subcharts) < len(chart.Metadata.Dependencies) {
+		fmt.Println("  ⚠️  Some dependencies are missing in charts/, their default images are not rewritten")
+	}
+	effective := copyValues(chart.Values)
+	for _, sub := range chart.Subcharts {
+		name := chart.dependencyFor(sub).valuesName()
+		overrides, _ := effective[name].(map[string]interface{})
+		effective[name] = coalesceValues(sub.Values, overrides)
+	}
+
+	var images []imageValue
+	collectImageValues(effective, "", &images)
+
+	valuesFile := filepath.Join(cfg.ChartDir, "values.yaml")
+	doc, err := readYAMLDocument(valuesFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse values.yaml: %w", err)
+	}
+
+	rewritten := 0
+	for _, image := range images {
+		from := image.Repository
+		if image.Registry != "" {
+			from = image.Registry + "/" + image.Repository
+		}
+		to, ok := rewriteRepository(from, image.Path, rules)
+		if !ok {
+			continue
+		}
+		if err := setImageValue(doc.Content[0], image, to); err != nil {
+			return err
+		}
+		fmt.Printf("  ✅ %s: %s → %s\n", image.Path, from, to)
+		rewritten++
+	}
+
+	if err := writeYAMLDocument(valuesFile, doc); err != nil {
+		return fmt.Errorf("failed to write values.yaml: %w", err)
+	}
+	fmt.Printf("✅ %d of %d image(s) rewritten\n", rewritten, len(images))
+	return nil
+}
+
+// collectImageValues finds the image keys in values, sorted by path
+func collectImageValues(values map[string]interface{}, path string, images *[]imageValue) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch v := values[k].(type) {
+		case string:
+			if k != "image" || v == "" {
+				continue
+			}
+			ref, err := parseImageReference(v)
+			if err != nil {
+				continue
+			}
+			*images = append(*images, imageValue{Path: path, Repository: ref.Repository, Suffix: v[len(ref.Repository):], Scalar: true})
+		case map[string]interface{}:
+			if repository, ok := v["repository"].(string); ok && k == "image" && repository != "" {
+				registry, _ := v["registry"].(string)
+				*images = append(*images, imageValue{Path: path, Registry: registry, Repository: repository})
+				continue
+			}
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			collectImageValues(v, child, images)
+		}
+	}
+}
+
+// rewriteRepository applies the first rule matching repository (with
+// registry) and returns the new repository
+func rewriteRepository(repository, path string, rules []ImageRewrite) (string, bool) {
+	qualified := qualifiedRepository(repository)
+	for _, rule := range rules {
+		if containsString(rule.Except, path) {
+			continue
+		}
+		if rule.From == "" {
+			_, rest, _ := strings.Cut(qualified, "/")
+			return strings.TrimSuffix(rule.To, "/") + "/" + rest, true
+		}
+		if from := qualifiedRepository(rule.From); strings.HasPrefix(qualified, from) {
+			return rule.To + qualified[len(from):], true
+		}
+	}
+	return "", false
+}
+
+// qualifiedRepository prefixes repository with docker.io when it names no
+// registry: goharbor/harbor-core is docker.io/goharbor/harbor-core and
+// alpine is docker.io/library/alpine
+func qualifiedRepository(repository string) string {
+	host, rest, _ := strings.Cut(repository, "/")
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		host, rest = "docker.io", repository
+	}
+	if host == "index.docker.io" {
+		host = "docker.io"
+	}
+	if host == "docker.io" && rest != "" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	return host + "/" + rest
+}
+
+// setImageValue writes the rewritten repository to values.yaml, creating
+// the keys for images that only have subchart defaults
+func setImageValue(root *yaml.Node, image imageValue, repository string) error {
+	dir := strings.ReplaceAll(image.Path, ".", "/")
+	if dir == "" {
+		dir = "."
+	}
+
+	if image.Scalar {
+		target, err := valuesTarget(root, dir)
+		if err != nil {
+			return err
+		}
+		setMappingScalar(target, "image", repository+image.Suffix)
+		return nil
+	}
+
+	target, err := valuesTarget(root, dir+"/image")
+	if err != nil {
+		return err
+	}
+	if image.Registry != "" {
+		registry, rest, _ := strings.Cut(repository, "/")
+		setMappingScalar(target, "registry", registry)
+		repository = rest
+	}
+	setMappingScalar(target, "repository", repository)
+	return nil
+}
+
+// setMappingScalar sets key in a mapping node to a string, keeping the
+// quoting style of an existing value
+func setMappingScalar(m *yaml.Node, key, value string) {
+	if node := mappingValue(m, key); node != nil {
+		node.Kind, node.Tag, node.Value = yaml.ScalarNode, "!!str", value
+		return
+	}
+	m.Content = append(m.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
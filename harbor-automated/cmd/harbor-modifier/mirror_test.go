@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQualifiedRepository(t *testing.T) {
+	tests := []struct{ repository, want string }{
+		{"goharbor/harbor-core", "docker.io/goharbor/harbor-core"},
+		{"alpine", "docker.io/library/alpine"},
+		{"docker.io/alpine", "docker.io/library/alpine"},
+		{"index.docker.io/goharbor/harbor-core", "docker.io/goharbor/harbor-core"},
+		{"docker.io/library/redis", "docker.io/library/redis"},
+		{"quay.io/prometheus/node-exporter", "quay.io/prometheus/node-exporter"},
+		{"localhost/harbor-core", "localhost/harbor-core"},
+		{"registry:5000/harbor-core", "registry:5000/harbor-core"},
+		{"goharbor/", "docker.io/goharbor/"},
+		{"quay.io/", "quay.io/"},
+	}
+	for _, tt := range tests {
+		if got := qualifiedRepository(tt.repository); got != tt.want {
+			t.Errorf("qualifiedRepository(%s) = %s, want %s", tt.repository, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteRepository(t *testing.T) {
+	rules := []ImageRewrite{
+		{From: "goharbor/", To: "mirror.example.com/goharbor/", Except: []string{"trivy"}},
+		{From: "docker.io/library/", To: "mirror.example.com/library/"},
+		{From: "quay.io/", To: "mirror.example.com/quay/"},
+	}
+	withCatchAll := append(rules, ImageRewrite{To: "registry.example.com/all/"})
+
+	tests := []struct {
+		name, repository, path string
+		rules                  []ImageRewrite
+		want                   string
+		ok                     bool
+	}{
+		{"prefix without registry", "goharbor/harbor-core", "core", rules, "mirror.example.com/goharbor/harbor-core", true},
+		{"prefix matches the qualified repository", "docker.io/goharbor/harbor-core", "core", rules, "mirror.example.com/goharbor/harbor-core", true},
+		{"official image", "redis", "redis", rules, "mirror.example.com/library/redis", true},
+		{"other registry", "quay.io/prometheus/node-exporter", "exporter", rules, "mirror.example.com/quay/prometheus/node-exporter", true},
+		{"no rule matches", "ghcr.io/org/app", "app", rules, "", false},
+		{"prefix is not a path match", "goharborx/core", "core", rules, "", false},
+		{"except skips the rule", "goharbor/trivy-adapter-photon", "trivy", rules, "", false},
+		{"except falls through to the next rule", "goharbor/trivy-adapter-photon", "trivy", withCatchAll, "registry.example.com/all/goharbor/trivy-adapter-photon", true},
+		{"empty from replaces the registry", "ghcr.io/org/app", "app", withCatchAll, "registry.example.com/all/org/app", true},
+		{"empty from keeps library/", "alpine", "backup", []ImageRewrite{{To: "registry.example.com/all"}}, "registry.example.com/all/library/alpine", true},
+		{"first matching rule wins", "goharbor/harbor-core", "core", withCatchAll, "mirror.example.com/goharbor/harbor-core", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rewriteRepository(tt.repository, tt.path, tt.rules)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("rewriteRepository(%s, %s) = %q %v, want %q %v", tt.repository, tt.path, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoadImageRewrites(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{ModificationsDir: dir, ImageRegistry: "registry.example.com/all"}
+
+	rules, err := loadImageRewrites(cfg)
+	if err != nil || len(rules) != 1 || rules[0].From != "" || rules[0].To != cfg.ImageRegistry {
+		t.Fatalf("without images.yaml: %+v %v", rules, err)
+	}
+
+	writeFiles(t, dir, map[string]string{imageRewritesFile: "rewrites:\n  - from: goharbor/\n    to: mirror.example.com/goharbor/\n"})
+	rules, err = loadImageRewrites(cfg)
+	if err != nil || len(rules) != 2 || rules[0].From != "goharbor/" || rules[1].To != cfg.ImageRegistry {
+		t.Fatalf("-image-registry must be the last rule: %+v %v", rules, err)
+	}
+
+	writeFiles(t, dir, map[string]string{imageRewritesFile: "rewrites:\n  - from: goharbor/\n"})
+	if _, err := loadImageRewrites(cfg); err == nil || !strings.Contains(err.Error(), "has no to") {
+		t.Fatalf("expected a missing to error, got %v", err)
+	}
+}
+
+func TestRewriteImageRegistries(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		ChartDir:         filepath.Join(dir, "chart"),
+		ModificationsDir: filepath.Join(dir, "modifications"),
+		ImageRegistry:    "registry.example.com/all",
+	}
+	writeFiles(t, dir, map[string]string{
+		"chart/Chart.yaml": `apiVersion: v2
+name: harbor
+version: 1.18.0
+dependencies:
+  - name: postgresql
+    version: 0.1.3
+    condition: postgresql.enabled
+`,
+		"chart/values.yaml": `# Harbor core
+core:
+  image:
+    repository: goharbor/harbor-core # upstream image
+    tag: v2.14.0
+trivy:
+  image:
+    repository: goharbor/trivy-adapter-photon
+    tag: v2.14.0
+backup:
+  image: relizaio/psql-awscli:25.11.0@sha256:d349d0b4780f560b0aba0432c9761180231c17526478799693a93d5b3a18f5df
+postgresql:
+  enabled: true
+`,
+		"chart/charts/postgresql/Chart.yaml": "apiVersion: v2\nname: postgresql\nversion: 0.1.3\n",
+		"chart/charts/postgresql/values.yaml": `image:
+  registry: docker.io
+  repository: bitnami/postgresql
+  tag: "17"
+metrics:
+  image:
+    registry: quay.io
+    repository: prometheuscommunity/postgres-exporter
+`,
+		"modifications/images.yaml": `rewrites:
+  - from: goharbor/
+    to: mirror.example.com/goharbor/
+    except:
+      - trivy
+  - from: quay.io/
+    to: mirror.example.com/quay/
+`,
+	})
+
+	if err := rewriteImageRegistries(cfg); err != nil {
+		t.Fatalf("rewriteImageRegistries: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(cfg.ChartDir, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := parseValues(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"core.image.repository":               "mirror.example.com/goharbor/harbor-core",
+		"core.image.tag":                      "v2.14.0",
+		"trivy.image.repository":              "registry.example.com/all/goharbor/trivy-adapter-photon",
+		"backup.image":                        "registry.example.com/all/relizaio/psql-awscli:25.11.0@sha256:d349d0b4780f560b0aba0432c9761180231c17526478799693a93d5b3a18f5df",
+		"postgresql.image.registry":           "registry.example.com",
+		"postgresql.image.repository":         "all/bitnami/postgresql",
+		"postgresql.metrics.image.registry":   "mirror.example.com",
+		"postgresql.metrics.image.repository": "quay/prometheuscommunity/postgres-exporter",
+		"postgresql.enabled":                  "true",
+	} {
+		got, ok := lookupValue(values, path)
+		if !ok || fmt.Sprint(got) != want {
+			t.Errorf("%s = %v, want %s", path, got, want)
+		}
+	}
+	if _, ok := lookupValue(values, "postgresql.image.tag"); ok {
+		t.Error("subchart override copied keys other than the rewritten ones")
+	}
+	for _, comment := range []string{"# Harbor core", "# upstream image"} {
+		if !strings.Contains(string(content), comment) {
+			t.Errorf("comment %q lost:\n%s", comment, content)
+		}
+	}
+}
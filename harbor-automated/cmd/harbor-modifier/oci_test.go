@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOCISourceDownload(t *testing.T) {
+	reg := newFakeRegistry(t)
+	archive := chartArchive(t, "harbor", "1.18.0+up", nil)
+	manifestDigest := reg.pushChart("charts/harbor", "1.18.0_up", archive)
+
+	tests := []struct {
+		name, ref, version string
+	}{
+		{"version as tag", "oci://" + reg.host() + "/charts/harbor", "1.18.0+up"},
+		{"tag in reference", "oci://" + reg.host() + "/charts/harbor:1.18.0_up", "ignored"},
+		{"digest in reference", "oci://" + reg.host() + "/charts/harbor@" + manifestDigest, "1.18.0+up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewOCISource(tt.ref, true)
+			if err != nil {
+				t.Fatalf("NewOCISource: %v", err)
+			}
+			data, cv, err := src.Download("harbor", tt.version)
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if !bytes.Equal(data, archive) {
+				t.Error("downloaded archive differs from the pushed one")
+			}
+			if cv.Version != "1.18.0+up" || cv.Digest != digestOf(archive) {
+				t.Errorf("chart version = %s %s, want 1.18.0+up %s", cv.Version, cv.Digest, digestOf(archive))
+			}
+			if want := "oci://" + reg.host() + "/charts/harbor@" + manifestDigest; cv.URLs[0] != want {
+				t.Errorf("URL = %s, want %s", cv.URLs[0], want)
+			}
+		})
+	}
+}
+
+func TestOCISourceCredentialsFromEnv(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.Username, reg.Password = "robot", "secret"
+	reg.pushChart("charts/harbor", "1.18.0", chartArchive(t, "harbor", "1.18.0", nil))
+
+	t.Setenv("HELM_REGISTRY_USERNAME", "robot")
+	t.Setenv("HELM_REGISTRY_PASSWORD", "secret")
+	src, err := NewOCISource("oci://"+reg.host()+"/charts/harbor", true)
+	if err != nil {
+		t.Fatalf("NewOCISource: %v", err)
+	}
+	if _, _, err := src.Download("harbor", "1.18.0"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+}
+
+func TestOCISourceErrors(t *testing.T) {
+	reg := newFakeRegistry(t)
+	archive := chartArchive(t, "harbor", "1.18.0", nil)
+	reg.pushChart("charts/harbor", "1.18.0", archive)
+	reg.addManifest("images/core", "v2.14.0", mediaTypeOCIManifest, ociManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers:    []ociDescriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: reg.addBlob([]byte("layer"))}},
+	})
+
+	tests := []struct {
+		name, ref, version, want string
+	}{
+		{"unknown tag", "oci://" + reg.host() + "/charts/harbor", "9.9.9", "failed to fetch chart manifest"},
+		{"not a chart", "oci://" + reg.host() + "/images/core", "v2.14.0", "is not a Helm chart"},
+		{"no version", "oci://" + reg.host() + "/charts/harbor", "", "no tag, digest or version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewOCISource(tt.ref, true)
+			if err != nil {
+				t.Fatalf("NewOCISource: %v", err)
+			}
+			if _, _, err := src.Download("harbor", tt.version); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// A tampered chart layer fails blob verification
+	reg.blobs[digestOf(archive)] = []byte("tampered")
+	src, _ := NewOCISource("oci://"+reg.host()+"/charts/harbor", true)
+	if _, _, err := src.Download("harbor", "1.18.0"); err == nil || !strings.Contains(err.Error(), "blob digest mismatch") {
+		t.Fatalf("expected a blob digest mismatch, got %v", err)
+	}
+}
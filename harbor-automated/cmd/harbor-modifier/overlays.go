@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overlayBasesFile (in modifications/) records the upstream template each
+// template overlay was derived from
+const overlayBasesFile = "template-overlays.yaml"
+
+// OverlayBases is the content of modifications/template-overlays.yaml
+type OverlayBases struct {
+	Overlays []OverlayBase `yaml:"overlays"`
+}
+
+// OverlayBase is the upstream chart version and template hash an overlay is based on
+type OverlayBase struct {
+	File    string `yaml:"file"`    // Path under template-overlays/ and the chart's templates/
+	Version string `yaml:"version"` // Upstream chart version
+	SHA256  string `yaml:"sha256"`  // SHA-256 of the upstream template in that version
+}
+
+// loadOverlayBases reads the overlay base record; a missing file is empty
+func loadOverlayBases(modDir string) (*OverlayBases, error) {
+	content, err := os.ReadFile(filepath.Join(modDir, overlayBasesFile))
+	if os.IsNotExist(err) {
+		return &OverlayBases{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", overlayBasesFile, err)
+	}
+
+	var bases OverlayBases
+	if err := yaml.Unmarshal(content, &bases); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", overlayBasesFile, err)
+	}
+	return &bases, nil
+}
+
+// save writes the overlay base record, sorted by file
+func (b *OverlayBases) save(modDir string) error {
+	sort.Slice(b.Overlays, func(i, j int) bool { return b.Overlays[i].File < b.Overlays[j].File })
+
+	content, err := yaml.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", overlayBasesFile, err)
+	}
+	header := "# Upstream templates the files in template-overlays/ are derived from.\n" +
+		"# Maintained by harbor-modifier (overlay-bases, rebase).\n"
+	if err := os.WriteFile(filepath.Join(modDir, overlayBasesFile), append([]byte(header), content...), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", overlayBasesFile, err)
+	}
+	return nil
+}
+
+func (b *OverlayBases) lookup(file string) *OverlayBase {
+	for i := range b.Overlays {
+		if b.Overlays[i].File == file {
+			return &b.Overlays[i]
+		}
+	}
+	return nil
+}
+
+// set records file as based on the given upstream version and content
+func (b *OverlayBases) set(file, version string, upstream []byte) {
+	base := OverlayBase{File: file, Version: version, SHA256: sha256Hex(upstream)}
+	if existing := b.lookup(file); existing != nil {
+		*existing = base
+		return
+	}
+	b.Overlays = append(b.Overlays, base)
+}
+
+// listOverlays returns the overlay files as slash paths relative to template-overlays/
+func listOverlays(modDir string) ([]string, error) {
+	overlaysDir := filepath.Join(modDir, "template-overlays")
+	if _, err := os.Stat(overlaysDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(overlaysDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(overlaysDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template overlays: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// pulledChartVersion returns the version in the pulled chart's Chart.yaml
+func pulledChartVersion(chartDir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read Chart.yaml: %w", err)
+	}
+	var metadata ChartMetadata
+	if err := yaml.Unmarshal(content, &metadata); err != nil {
+		return "", fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+	return metadata.Version, nil
+}
+
+// upstreamChartFiles downloads (or takes from the cache) an upstream chart
+// version and returns its files
+func upstreamChartFiles(cfg *Config, version string) (map[string][]byte, error) {
+	src, err := newChartSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := src.Download(chartName, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s %s from %s: %w", chartName, version, cfg.Source, err)
+	}
+	return readChartArchive(data)
+}
+
+// runOverlayBases implements `harbor-modifier overlay-bases -version <version>`:
+// record the templates of an upstream chart version as the bases of the
+// template overlays. This is its own step, so a build never writes into
+// modifications/.
+func runOverlayBases(args []string) error {
+	flags := flag.NewFlagSet("overlay-bases", flag.ExitOnError)
+	version := flags.String("version", defaultVersion, "Upstream chart version the overlays are based on")
+	source := flags.String("source", defaultSource, "Harbor chart source: Helm repository URL or oci://host/repo/harbor[:tag|@digest]")
+	plainHTTP := flags.Bool("plain-http", false, "Use plain HTTP for oci:// sources")
+	cacheDir := flags.String("cache-dir", defaultCacheDir(), "Upstream chart cache directory (empty disables caching)")
+	offline := flags.Bool("offline", false, "Use only cached upstream charts, fail on cache miss")
+	fromTgz := flags.String("from-tgz", "", "Use a local upstream chart archive instead of downloading (its version is recorded)")
+	flags.Parse(args)
+
+	cfg := &Config{
+		Source:           *source,
+		PlainHTTP:        *plainHTTP,
+		CacheDir:         *cacheDir,
+		Offline:          *offline,
+		ModificationsDir: filepath.Join(mustGetwd(), "modifications"),
+	}
+
+	var upstream map[string][]byte
+	if *fromTgz != "" {
+		data, err := os.ReadFile(*fromTgz)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", *fromTgz, err)
+		}
+		if upstream, err = readChartArchive(data); err != nil {
+			return fmt.Errorf("%s: %w", *fromTgz, err)
+		}
+	} else {
+		var err error
+		if upstream, err = upstreamChartFiles(cfg, *version); err != nil {
+			return err
+		}
+	}
+
+	var metadata ChartMetadata
+	if err := yaml.Unmarshal(upstream["Chart.yaml"], &metadata); err != nil {
+		return fmt.Errorf("failed to parse upstream Chart.yaml: %w", err)
+	}
+	fmt.Printf("📝 Recording template overlay bases (%s %s)...\n", chartName, metadata.Version)
+
+	overlays, err := listOverlays(cfg.ModificationsDir)
+	if err != nil {
+		return err
+	}
+	bases, err := loadOverlayBases(cfg.ModificationsDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range overlays {
+		content, ok := upstream["templates/"+file]
+		if !ok {
+			fmt.Printf("  ⚠️  %s: no upstream template in %s, overlay adds a new file\n", file, metadata.Version)
+			continue
+		}
+		bases.set(file, metadata.Version, content)
+		fmt.Printf("  📝 %s\n", file)
+	}
+
+	if err := bases.save(cfg.ModificationsDir); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Overlay bases recorded in modifications/%s\n", overlayBasesFile)
+	return nil
+}
+
+// checkOverlayDrift compares the pulled (unmodified) upstream templates with
+// the bases recorded for the overlays that replace them. Changed upstream
+// templates are reported with their diff since the base; in strict mode
+// they, and overlays without a recorded base, fail the build.
+func checkOverlayDrift(cfg *Config) error {
+	fmt.Println("\n🔍 Checking template overlays against upstream...")
+
+	overlays, err := listOverlays(cfg.ModificationsDir)
+	if err != nil {
+		return err
+	}
+	if len(overlays) == 0 {
+		fmt.Println("  ⏭️  No template overlays, skipping...")
+		return nil
+	}
+
+	bases, err := loadOverlayBases(cfg.ModificationsDir)
+	if err != nil {
+		return err
+	}
+	version, err := pulledChartVersion(cfg.ChartDir)
+	if err != nil {
+		return err
+	}
+
+	baseCharts := make(map[string]map[string][]byte)
+	var drifted, unrecorded []string
+	for _, file := range overlays {
+		upstream, err := os.ReadFile(filepath.Join(cfg.ChartDir, "templates", filepath.FromSlash(file)))
+		if os.IsNotExist(err) {
+			fmt.Printf("  ⚠️  %s: no upstream template in %s, overlay adds a new file\n", file, version)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read upstream %s: %w", file, err)
+		}
+
+		base := bases.lookup(file)
+		if base == nil {
+			fmt.Printf("  ⚠️  %s: no upstream base recorded in %s (run harbor-modifier overlay-bases)\n", file, overlayBasesFile)
+			unrecorded = append(unrecorded, file)
+			continue
+		}
+		if base.SHA256 == sha256Hex(upstream) {
+			fmt.Printf("  ✅ %s (unchanged since %s)\n", file, base.Version)
+			continue
+		}
+
+		fmt.Printf("  ⚠️  %s: upstream changed since %s, the overlay may drop upstream fixes\n", file, base.Version)
+		drifted = append(drifted, file)
+
+		// Show what changed upstream since the overlay's base
+		if baseCharts[base.Version] == nil {
+			files, err := upstreamChartFiles(cfg, base.Version)
+			if err != nil {
+				fmt.Printf("      (upstream diff unavailable: %v)\n", err)
+				continue
+			}
+			baseCharts[base.Version] = files
+		}
+		baseContent, ok := baseCharts[base.Version]["templates/"+file]
+		if !ok || sha256Hex(baseContent) != base.SHA256 {
+			fmt.Printf("      (upstream diff unavailable: %s %s does not match the recorded base)\n", chartName, base.Version)
+			continue
+		}
+		diff := unifiedDiff(base.Version+"/templates/"+file, version+"/templates/"+file, string(baseContent), string(upstream))
+		fmt.Println(indent(diff, "      "))
+	}
+
+	if cfg.Strict {
+		if len(unrecorded) > 0 {
+			return fmt.Errorf("%d template overlay(s) have no recorded upstream base: run harbor-modifier overlay-bases", len(unrecorded))
+		}
+		if len(drifted) > 0 {
+			return fmt.Errorf("%d template overlay(s) are based on an older upstream template: run harbor-modifier rebase, or update them and run harbor-modifier overlay-bases", len(drifted))
+		}
+	}
+	if len(drifted) > 0 || len(unrecorded) > 0 {
+		fmt.Printf("⚠️  %d template overlay(s) drifted from upstream, %d without a recorded base\n", len(drifted), len(unrecorded))
+		return nil
+	}
+	fmt.Println("✅ Template overlays match upstream")
+	return nil
+}
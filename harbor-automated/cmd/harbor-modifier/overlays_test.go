@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeOverlayFixture creates a pulled chart with templates/core/cm.yaml and
+// a modifications directory with an overlay for it
+func writeOverlayFixture(t *testing.T, upstream string) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &Config{
+		ChartDir:         filepath.Join(dir, "chart"),
+		ModificationsDir: filepath.Join(dir, "modifications"),
+	}
+	writeFiles(t, dir, map[string]string{
+		"chart/Chart.yaml":                             "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"chart/templates/core/cm.yaml":                 upstream,
+		"modifications/template-overlays/core/cm.yaml": "overlay\n",
+	})
+	return cfg
+}
+
+func TestCheckOverlayDriftMissingBase(t *testing.T) {
+	cfg := writeOverlayFixture(t, "upstream\n")
+	if err := checkOverlayDrift(cfg); err != nil {
+		t.Fatalf("non-strict: %v", err)
+	}
+
+	cfg.Strict = true
+	if err := checkOverlayDrift(cfg); err == nil || !strings.Contains(err.Error(), "no recorded upstream base") {
+		t.Fatalf("strict: expected a missing base error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ModificationsDir, overlayBasesFile)); !os.IsNotExist(err) {
+		t.Error("the build wrote into modifications/")
+	}
+}
+
+func TestCheckOverlayDriftRecordedBase(t *testing.T) {
+	cfg := writeOverlayFixture(t, "upstream\n")
+	cfg.Strict = true
+	bases := &OverlayBases{}
+	bases.set("core/cm.yaml", "1.18.0", []byte("upstream\n"))
+	if err := bases.save(cfg.ModificationsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOverlayDrift(cfg); err != nil {
+		t.Fatalf("unchanged upstream: %v", err)
+	}
+
+	// Upstream changed since the base; the base version cannot be fetched
+	// offline without a cache, so only the drift itself is reported
+	cfg = writeOverlayFixture(t, "upstream fixed\n")
+	cfg.Strict, cfg.Offline = true, true
+	if err := bases.save(cfg.ModificationsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOverlayDrift(cfg); err == nil || !strings.Contains(err.Error(), "older upstream template") {
+		t.Fatalf("expected a drift error, got %v", err)
+	}
+}
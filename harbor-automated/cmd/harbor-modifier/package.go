@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"gopkg.in/yaml.v3"
+)
+
+// relizaVersionSuffix matches the "-reliza.N" suffix of a packaged chart version
+var relizaVersionSuffix = regexp.MustCompile(`-reliza\.\d+$`)
+
+// chartVersionLine matches the top-level version key of Chart.yaml
+var chartVersionLine = regexp.MustCompile(`(?m)^version:.*$`)
+
+// runPackage implements `harbor-modifier package -iteration N`: set the
+// chart version to {version}-reliza.{N} and write a reproducible .tgz,
+// with -sign also its provenance file
+func runPackage(args []string) error {
+	flags := flag.NewFlagSet("package", flag.ExitOnError)
+	chartDir := flags.String("chart", "harbor-helm", "Chart directory to package")
+	iteration := flags.Int("iteration", 0, "Reliza iteration N of the {version}-reliza.{N} chart version")
+	version := flags.String("version", "", "Upstream chart version (default: Chart.yaml version without -reliza.N)")
+	destination := flags.String("destination", "packages", "Directory to write the .tgz to")
+	sign := flags.Bool("sign", false, "Write a helm provenance file (.prov) next to the .tgz")
+	keyPath := flags.String("key", "", "Keyring file with the OpenPGP signing key (armored or binary)")
+	keyName := flags.String("key-name", "", "User ID (substring) of the signing key, if the keyring has several")
+	flags.Parse(args)
+
+	if *iteration < 1 {
+		return fmt.Errorf("package needs -iteration N (N >= 1)")
+	}
+	if *sign && *keyPath == "" {
+		return fmt.Errorf("-sign needs -key <keyring file>")
+	}
+
+	// Check the key before touching Chart.yaml
+	var signer *openpgp.Entity
+	if *sign {
+		keyring, err := loadKeyring(*keyPath)
+		if err != nil {
+			return err
+		}
+		if signer, err = signingEntity(keyring, *keyName); err != nil {
+			return err
+		}
+	}
+
+	chartVersion, err := setChartVersion(*chartDir, *version, *iteration)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📦 Packaging %s %s...\n", *chartDir, chartVersion)
+
+	archive, name, err := packageChart(*chartDir, sourceDateEpoch())
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*destination, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", *destination, err)
+	}
+	target := filepath.Join(*destination, fmt.Sprintf("%s-%s.tgz", name, chartVersion))
+	if err := os.WriteFile(target, archive, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+
+	fmt.Printf("✅ %s\n   sha256:%s\n", target, sha256Hex(archive))
+
+	if signer != nil {
+		prov, err := signChart(archive, filepath.Base(target), signer)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target+".prov", prov, 0644); err != nil {
+			return fmt.Errorf("failed to write %s.prov: %w", target, err)
+		}
+		fmt.Printf("🔏 %s.prov (signed by %s)\n", target, signer.PrimaryIdentity().Name)
+	}
+	return nil
+}
+
+// setChartVersion writes {version}-reliza.{iteration} to Chart.yaml and
+// returns it. Without version, the Chart.yaml version is used with any
+// previous -reliza.N suffix removed.
+func setChartVersion(chartDir, version string, iteration int) (string, error) {
+	chartFile := filepath.Join(chartDir, "Chart.yaml")
+	content, err := os.ReadFile(chartFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Chart.yaml: %w", err)
+	}
+	if version == "" {
+		var metadata ChartMetadata
+		if err := yaml.Unmarshal(content, &metadata); err != nil {
+			return "", fmt.Errorf("failed to parse Chart.yaml: %w", err)
+		}
+		version = relizaVersionSuffix.ReplaceAllString(metadata.Version, "")
+	}
+	if version == "" {
+		return "", fmt.Errorf("Chart.yaml has no version, use -version")
+	}
+
+	chartVersion := fmt.Sprintf("%s-reliza.%d", version, iteration)
+	if !chartVersionLine.Match(content) {
+		return "", fmt.Errorf("Chart.yaml has no top-level version key")
+	}
+	updated := chartVersionLine.ReplaceAllLiteral(content, []byte("version: "+chartVersion))
+	if err := os.WriteFile(chartFile, updated, 0644); err != nil {
+		return "", fmt.Errorf("failed to write Chart.yaml: %w", err)
+	}
+	return chartVersion, nil
+}
+
+// sourceDateEpoch returns the archive timestamp: SOURCE_DATE_EPOCH if set
+// (reproducible-builds.org convention), else the Unix epoch
+func sourceDateEpoch() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// packageChart builds the chart archive like helm package, but byte for
+// byte reproducible: entries sorted by path, every entry with mtime,
+// root ownership and mode 0644, and a gzip header without name or time.
+// Files matched by .helmignore are left out. It returns the archive and
+// the chart name.
+func packageChart(chartDir string, mtime time.Time) ([]byte, string, error) {
+	content, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read Chart.yaml: %w", err)
+	}
+	var metadata ChartMetadata
+	if err := yaml.Unmarshal(content, &metadata); err != nil {
+		return nil, "", fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+	if metadata.Name == "" {
+		return nil, "", fmt.Errorf("Chart.yaml has no name")
+	}
+
+	files, err := chartPackageFiles(chartDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, "", err
+	}
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(chartDir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     metadata.Name + "/" + file,
+			Size:     int64(len(data)),
+			Mode:     0644,
+			ModTime:  mtime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, "", fmt.Errorf("failed to write %s: %w", file, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, "", fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), metadata.Name, nil
+}
+
+// chartPackageFiles lists the regular files of the chart that .helmignore
+// does not exclude, as sorted slash paths
+func chartPackageFiles(chartDir string) ([]string, error) {
+	rules, err := loadHelmignore(chartDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = filepath.WalkDir(chartDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(chartDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if rules.ignore(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chart files: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// helmignoreRule is a parsed .helmignore line
+type helmignoreRule struct {
+	pattern  string
+	negate   bool
+	mustDir  bool // Pattern ends with "/"
+	anchored bool // Pattern starts with "/"
+}
+
+type helmignoreRules []helmignoreRule
+
+// loadHelmignore parses the chart's .helmignore plus the rule helm always
+// adds (hidden files in templates/); a missing file has only the default
+func loadHelmignore(chartDir string) (helmignoreRules, error) {
+	lines := []string{"templates/.?*"}
+	content, err := os.ReadFile(filepath.Join(chartDir, ".helmignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read .helmignore: %w", err)
+	}
+	lines = append(lines, strings.Split(string(content), "\n")...)
+
+	var rules helmignoreRules
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "**") {
+			return nil, fmt.Errorf(".helmignore: %q: double-star (**) syntax is not supported", line)
+		}
+		if _, err := path.Match(line, "abc"); err != nil {
+			return nil, fmt.Errorf(".helmignore: %q: %w", line, err)
+		}
+
+		rule := helmignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.mustDir = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ignore reports whether the slash path rel is excluded, with the same
+// semantics as helm: patterns without "/" match the base name, others the
+// whole path; a negated rule excludes everything it does not match
+func (rules helmignoreRules) ignore(rel string, isDir bool) bool {
+	for _, rule := range rules {
+		name := rel
+		if !rule.anchored && !strings.Contains(rule.pattern, "/") {
+			name = path.Base(rel)
+		}
+		matched, _ := path.Match(rule.pattern, name)
+
+		if rule.negate {
+			if (rule.mustDir && !isDir) || !matched {
+				return true
+			}
+			continue
+		}
+		if rule.mustDir && !isDir {
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
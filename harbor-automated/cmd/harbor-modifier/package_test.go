@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePackageFixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Chart.yaml":               "apiVersion: v2\nname: harbor\nversion: 1.18.0-reliza.3\nappVersion: 2.14.0\n",
+		"values.yaml":              "core:\n  replicas: 1\n",
+		".helmignore":              "# Editor files\n*.swp\n/ci/\n",
+		"templates/core.yaml":      "kind: ConfigMap\n",
+		"templates/.hidden.yaml":   "kind: Secret\n",
+		"templates/core.yaml.swp":  "swap\n",
+		"ci/test-values.yaml":      "core: {}\n",
+		"charts/postgresql/ci.txt": "kept\n",
+	})
+	return dir
+}
+
+func TestPackageChartReproducible(t *testing.T) {
+	dir := writePackageFixture(t)
+	mtime := time.Unix(1700000000, 0).UTC()
+
+	first, name, err := packageChart(dir, mtime)
+	if err != nil {
+		t.Fatalf("packageChart: %v", err)
+	}
+	if name != "harbor" {
+		t.Errorf("name = %s, want harbor", name)
+	}
+
+	// File modification times and creation order do not matter
+	later := time.Now().Add(time.Hour)
+	for _, file := range []string{"values.yaml", "templates/core.yaml"} {
+		if err := os.Chtimes(filepath.Join(dir, file), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second, _, err := packageChart(dir, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256Hex(first) != sha256Hex(second) {
+		t.Errorf("archives differ: sha256:%s, sha256:%s", sha256Hex(first), sha256Hex(second))
+	}
+
+	files, err := readChartArchive(first)
+	if err != nil {
+		t.Fatalf("readChartArchive: %v", err)
+	}
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	want := []string{".helmignore", "Chart.yaml", "charts/postgresql/ci.txt", "templates/core.yaml", "values.yaml"}
+	if len(paths) != len(want) {
+		t.Fatalf("archive files = %v, want %v", paths, want)
+	}
+	for _, path := range want {
+		if _, ok := files[path]; !ok {
+			t.Errorf("%s missing from the archive", path)
+		}
+	}
+
+	other, _, err := packageChart(dir, mtime.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, other) {
+		t.Error("archive does not depend on the source date")
+	}
+}
+
+func TestHelmignore(t *testing.T) {
+	tests := []struct {
+		name, helmignore string
+		ignored          []string // Slash paths, a trailing "/" marks a directory
+		kept             []string
+	}{
+		{"base name pattern", "*.swp\n", []string{"a.swp", "templates/a.swp"}, []string{"a.yaml", "swp/"}},
+		{"comments and blank lines", "# *.yaml\n\n", nil, []string{"values.yaml"}},
+		{"path pattern", "templates/*.txt\n", []string{"templates/notes.txt"}, []string{"notes.txt", "templates/sub/notes.txt"}},
+		{"directory only", "ci/\n", []string{"ci/", "templates/ci/"}, []string{"ci", "templates/ci"}},
+		{"anchored", "/ci\n", []string{"ci/", "ci"}, []string{"templates/ci/", "templates/ci"}},
+		{"negation keeps only matches", "!*.yaml\n", []string{"README.md"}, []string{"values.yaml", "templates/core.yaml"}},
+		{"negated directory", "!templates/\n", []string{"values.yaml"}, []string{"templates/"}},
+		{"hidden templates", "", []string{"templates/.hidden.yaml"}, []string{".helmignore", "templates/core.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{".helmignore": tt.helmignore})
+			rules, err := loadHelmignore(dir)
+			if err != nil {
+				t.Fatalf("loadHelmignore: %v", err)
+			}
+			for _, path := range tt.ignored {
+				if !rules.ignore(strings.TrimSuffix(path, "/"), strings.HasSuffix(path, "/")) {
+					t.Errorf("%s not ignored", path)
+				}
+			}
+			for _, path := range tt.kept {
+				if rules.ignore(strings.TrimSuffix(path, "/"), strings.HasSuffix(path, "/")) {
+					t.Errorf("%s ignored", path)
+				}
+			}
+		})
+	}
+
+	for helmignore, want := range map[string]string{
+		"templates/**/*.txt\n": "double-star (**) syntax is not supported",
+		"[a-\n":                "syntax error in pattern",
+	} {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{".helmignore": helmignore})
+		if _, err := loadHelmignore(dir); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("loadHelmignore(%q): expected %q, got %v", helmignore, want, err)
+		}
+	}
+}
+
+func TestSetChartVersion(t *testing.T) {
+	tests := []struct {
+		name, chart, version string
+		iteration            int
+		want                 string
+	}{
+		{"upstream version", "name: harbor\nversion: 1.18.0\n", "", 1, "1.18.0-reliza.1"},
+		{"strips a previous iteration", "name: harbor\nversion: 1.18.0-reliza.3\n", "", 4, "1.18.0-reliza.4"},
+		{"keeps other prerelease suffixes", "name: harbor\nversion: 1.18.0-rc.1\n", "", 1, "1.18.0-rc.1-reliza.1"},
+		{"explicit version", "name: harbor\nversion: 1.18.0-reliza.3\n", "1.19.0", 1, "1.19.0-reliza.1"},
+		{"nested version keys untouched", "name: harbor\nversion: 1.18.0\ndependencies:\n  - name: postgresql\n    version: 0.1.3\n", "", 2, "1.18.0-reliza.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"Chart.yaml": tt.chart})
+			got, err := setChartVersion(dir, tt.version, tt.iteration)
+			if err != nil {
+				t.Fatalf("setChartVersion: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("version = %s, want %s", got, tt.want)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), "\nversion: "+tt.want+"\n") || strings.Count(string(content), "version:") != strings.Count(tt.chart, "version:") {
+				t.Errorf("Chart.yaml:\n%s", content)
+			}
+			if strings.Contains(tt.chart, "0.1.3") && !strings.Contains(string(content), "    version: 0.1.3\n") {
+				t.Errorf("dependency version changed:\n%s", content)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Chart.yaml": "name: harbor\n"})
+	if _, err := setChartVersion(dir, "", 1); err == nil || !strings.Contains(err.Error(), "no version") {
+		t.Errorf("expected a missing version error, got %v", err)
+	}
+}
+
+func TestRunPackage(t *testing.T) {
+	dir := writePackageFixture(t)
+	destination := t.TempDir()
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	args := []string{"-chart", dir, "-iteration", "5", "-destination", destination}
+	if err := runPackage(args); err != nil {
+		t.Fatalf("package: %v", err)
+	}
+	archive, err := os.ReadFile(filepath.Join(destination, "harbor-1.18.0-reliza.5.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Packaging the same iteration again gives the same archive
+	if err := runPackage(args); err != nil {
+		t.Fatalf("package again: %v", err)
+	}
+	again, err := os.ReadFile(filepath.Join(destination, "harbor-1.18.0-reliza.5.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256Hex(archive) != sha256Hex(again) {
+		t.Errorf("repackaged archive differs: sha256:%s, sha256:%s", sha256Hex(archive), sha256Hex(again))
+	}
+
+	for _, args := range [][]string{{"-chart", dir}, {"-chart", dir, "-iteration", "1", "-sign"}} {
+		if err := runPackage(args); err == nil {
+			t.Errorf("package %v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PatchFile is a single file in modifications/patches/
+type PatchFile struct {
+	Patches []Patch `yaml:"patches"`
+}
+
+// Patch replaces a block of upstream text in a chart file, or a named
+// {{ define }} block when Define is set
+type Patch struct {
+	Description string `yaml:"description"`
+	Target      string `yaml:"target"`   // Path relative to the chart root
+	Match       string `yaml:"match"`    // Exact upstream text to find
+	Define      string `yaml:"define"`   // Template name to replace, rename or delete
+	Rename      string `yaml:"rename"`   // New name for Define
+	Delete      bool   `yaml:"delete"`   // Remove Define and its doc comment
+	Replace     string `yaml:"replace"`  // Replacement text (empty removes the match)
+	Count       int    `yaml:"count"`    // Expected number of matches (default 1)
+	Required    bool   `yaml:"required"` // Fail in strict mode when the match count is wrong
+
+	source string // Patch file the patch was loaded from
+}
+
+// loadPatches reads all patch files in name order
+func loadPatches(patchesDir string) ([]Patch, error) {
+	files, err := filepath.Glob(filepath.Join(patchesDir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob patches: %w", err)
+	}
+
+	var patches []Patch
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		var pf PatchFile
+		if err := yaml.Unmarshal(content, &pf); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+
+		for i, p := range pf.Patches {
+			if p.Target == "" || (p.Match == "") == (p.Define == "") {
+				return nil, fmt.Errorf("%s: patch %d needs target and one of match or define", filepath.Base(file), i+1)
+			}
+			if p.Define != "" && p.Delete && p.Rename != "" {
+				return nil, fmt.Errorf("%s: patch %d cannot both rename and delete %s", filepath.Base(file), i+1, p.Define)
+			}
+			if p.Count == 0 {
+				p.Count = 1
+			}
+			p.source = filepath.Base(file)
+			patches = append(patches, p)
+		}
+	}
+
+	return patches, nil
+}
+
+func applyPatches(cfg *Config) error {
+	fmt.Println("  → Applying patches...")
+
+	patchesDir := filepath.Join(cfg.ModificationsDir, "patches")
+
+	// Check if patches directory exists
+	if _, err := os.Stat(patchesDir); os.IsNotExist(err) {
+		fmt.Println("    ⏭️  No patches, skipping...")
+		return nil
+	}
+
+	patches, err := loadPatches(patchesDir)
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	var failures []string
+	for _, p := range patches {
+		// A required patch that does not match fails in strict mode, any
+		// other mismatch is a warning
+		mismatch := func(msg string) (fatal bool) {
+			msg = fmt.Sprintf("%s: %s: %s", p.source, p.Description, msg)
+			if p.Required && cfg.Strict {
+				failures = append(failures, msg)
+				return true
+			}
+			fmt.Printf("    ⚠️  %s\n", msg)
+			return false
+		}
+
+		targetFile := filepath.Join(cfg.ChartDir, filepath.FromSlash(p.Target))
+
+		// A missing target is the same as a match that is not found
+		content, err := os.ReadFile(targetFile)
+		if os.IsNotExist(err) {
+			mismatch(fmt.Sprintf("target %s does not exist", p.Target))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p.Target, err)
+		}
+
+		if p.Define != "" {
+			defines, err := findDefines(p.Target, string(content))
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.source, err)
+			}
+			matches := len(definesNamed(defines, p.Define))
+			if matches != p.Count {
+				if mismatch(fmt.Sprintf("define %q matched %d time(s) in %s, expected %d", p.Define, matches, p.Target, p.Count)) || matches == 0 {
+					continue
+				}
+			}
+			if err := applyDefinePatch(targetFile, p, string(content)); err != nil {
+				return err
+			}
+			applied++
+			continue
+		}
+
+		matches := strings.Count(string(content), p.Match)
+		if matches != p.Count {
+			if mismatch(fmt.Sprintf("matched %d time(s) in %s, expected %d", matches, p.Target, p.Count)) || matches == 0 {
+				continue
+			}
+		}
+
+		newContent := strings.Replace(string(content), p.Match, p.Replace, p.Count)
+		if err := os.WriteFile(targetFile, []byte(newContent), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", p.Target, err)
+		}
+		applied++
+	}
+
+	if len(failures) > 0 {
+		fmt.Println("    ❌ Required patches did not match:")
+		for _, f := range failures {
+			fmt.Printf("       - %s\n", f)
+		}
+		return fmt.Errorf("%d required patch(es) failed in strict mode", len(failures))
+	}
+
+	fmt.Printf("    ✅ Applied %d of %d patch(es)\n", applied, len(patches))
+	return nil
+}
+
+// applyDefinePatch replaces, renames or deletes the first p.Count define
+// blocks named p.Define in targetFile
+func applyDefinePatch(targetFile string, p Patch, content string) error {
+	var newContent string
+	var err error
+	switch {
+	case p.Delete:
+		newContent, err = deleteDefine(p.Target, content, p.Define, p.Count)
+	case p.Rename != "":
+		newContent, err = renameDefine(p.Target, content, p.Define, p.Rename, p.Count)
+	default:
+		newContent, err = replaceDefine(p.Target, content, p.Define, p.Replace, p.Count)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.source, err)
+	}
+
+	if _, err := findDefines(p.Target, newContent); err != nil {
+		return fmt.Errorf("%s: %s leaves an invalid template: %w", p.source, p.Description, err)
+	}
+
+	if err := os.WriteFile(targetFile, []byte(newContent), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", p.Target, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePatchFixture creates a chart with templates/_helpers.tpl and a
+// modifications directory holding one patch file
+func writePatchFixture(t *testing.T, helpers, patches string) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &Config{
+		ChartDir:         filepath.Join(dir, "chart"),
+		ModificationsDir: filepath.Join(dir, "modifications"),
+	}
+	writeFiles(t, dir, map[string]string{
+		"chart/templates/_helpers.tpl":       helpers,
+		"modifications/patches/01-test.yaml": patches,
+	})
+	return cfg
+}
+
+func readHelpers(t *testing.T, cfg *Config) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(cfg.ChartDir, "templates", "_helpers.tpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+const repeatedDefine = `{{- define "harbor.a" -}}one{{- end -}}
+{{- define "harbor.a" -}}two{{- end -}}
+`
+
+func TestDefinePatchCount(t *testing.T) {
+	patches := `patches:
+  - description: rename a
+    target: templates/_helpers.tpl
+    define: harbor.a
+    rename: harbor.b
+    required: true
+`
+
+	t.Run("strict fails on more matches than count", func(t *testing.T) {
+		cfg := writePatchFixture(t, repeatedDefine, patches)
+		cfg.Strict = true
+		if err := applyPatches(cfg); err == nil || !strings.Contains(err.Error(), "required patch") {
+			t.Fatalf("expected a strict mode failure, got %v", err)
+		}
+		if got := readHelpers(t, cfg); got != repeatedDefine {
+			t.Errorf("helpers changed despite the failure:\n%s", got)
+		}
+	})
+
+	t.Run("non-strict applies to the first count blocks", func(t *testing.T) {
+		cfg := writePatchFixture(t, repeatedDefine, patches)
+		if err := applyPatches(cfg); err != nil {
+			t.Fatalf("applyPatches: %v", err)
+		}
+		want := "{{- define \"harbor.b\" -}}one{{- end -}}\n{{- define \"harbor.a\" -}}two{{- end -}}\n"
+		if got := readHelpers(t, cfg); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("count covers every block", func(t *testing.T) {
+		cfg := writePatchFixture(t, repeatedDefine, patches+"    count: 2\n")
+		cfg.Strict = true
+		if err := applyPatches(cfg); err != nil {
+			t.Fatalf("applyPatches: %v", err)
+		}
+		if got := readHelpers(t, cfg); strings.Contains(got, "harbor.a") {
+			t.Errorf("harbor.a left in helpers:\n%s", got)
+		}
+	})
+}
+
+func TestPatchMissingTarget(t *testing.T) {
+	patches := `patches:
+  - description: missing
+    target: templates/missing.yaml
+    match: foo
+    required: true
+  - description: present
+    target: templates/_helpers.tpl
+    match: one
+    replace: uno
+`
+
+	cfg := writePatchFixture(t, repeatedDefine, patches)
+	if err := applyPatches(cfg); err != nil {
+		t.Fatalf("non-strict: %v", err)
+	}
+	if got := readHelpers(t, cfg); !strings.Contains(got, "uno") {
+		t.Errorf("patch after the missing target was not applied:\n%s", got)
+	}
+
+	cfg = writePatchFixture(t, repeatedDefine, patches)
+	cfg.Strict = true
+	if err := applyPatches(cfg); err == nil {
+		t.Fatal("strict: expected an error for the missing required target")
+	}
+}
+
+func TestDefinePatchBlockInHelpers(t *testing.T) {
+	patches := `patches:
+  - description: replace fullname
+    target: templates/_helpers.tpl
+    define: harbor.fullname
+    required: true
+    replace: |-
+      {{- define "harbor.fullname" -}}harbor{{- end -}}
+`
+	cfg := writePatchFixture(t, helpersWithBlock, patches)
+	cfg.Strict = true
+	if err := applyPatches(cfg); err != nil {
+		t.Fatalf("applyPatches: %v", err)
+	}
+	if got := readHelpers(t, cfg); !strings.Contains(got, `{{- define "harbor.fullname" -}}harbor{{- end -}}`) {
+		t.Errorf("define not replaced:\n%s", got)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"gopkg.in/yaml.v3"
+)
+
+// passphraseEnv holds the passphrase of an encrypted signing key (same
+// variable as helm package --sign)
+const passphraseEnv = "HELM_KEY_PASSPHRASE"
+
+// provenanceSeparator ends the Chart.yaml part of a provenance message;
+// "---" is not allowed inside a clearsigned block, so helm uses the YAML
+// document end marker
+const provenanceSeparator = "\n...\n"
+
+// pgpConfig matches the signatures helm creates
+var pgpConfig = &packet.Config{DefaultHash: crypto.SHA512}
+
+// provenanceSums is the checksum part of a provenance message
+type provenanceSums struct {
+	Files map[string]string `yaml:"files"`
+}
+
+// loadKeyring reads an armored or binary OpenPGP keyring file
+func loadKeyring(path string) (openpgp.EntityList, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keyring %s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// signingEntity selects the private key to sign with: the one whose user
+// ID contains name, or the only private key in the keyring
+func signingEntity(keyring openpgp.EntityList, name string) (*openpgp.Entity, error) {
+	var candidates []*openpgp.Entity
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if name == "" {
+			candidates = append(candidates, entity)
+			continue
+		}
+		for id := range entity.Identities {
+			if strings.Contains(id, name) {
+				candidates = append(candidates, entity)
+				break
+			}
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && name != "":
+		return nil, fmt.Errorf("no private key matching %q in the keyring", name)
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("the keyring contains no private key")
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("the keyring contains %d matching private keys, select one with -key-name", len(candidates))
+	}
+
+	entity := candidates[0]
+	encrypted := entity.PrivateKey.Encrypted
+	for _, subkey := range entity.Subkeys {
+		encrypted = encrypted || (subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted)
+	}
+	if encrypted {
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("the signing key is encrypted: set %s", passphraseEnv)
+		}
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt the signing key: %w", err)
+		}
+	}
+	return entity, nil
+}
+
+// provenanceMessage builds the signed part of a .prov file: the chart's
+// Chart.yaml, the separator and the SHA-256 of the archive
+func provenanceMessage(archive []byte, archiveName string) ([]byte, error) {
+	files, err := readChartArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	chartYAML, ok := files["Chart.yaml"]
+	if !ok {
+		return nil, fmt.Errorf("%s has no Chart.yaml", archiveName)
+	}
+	var metadata map[string]interface{}
+	if err := yaml.Unmarshal(chartYAML, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+
+	message, err := encodeYAML(metadata)
+	if err != nil {
+		return nil, err
+	}
+	message = append(message, provenanceSeparator...)
+
+	sums, err := encodeYAML(provenanceSums{Files: map[string]string{archiveName: "sha256:" + sha256Hex(archive)}})
+	if err != nil {
+		return nil, err
+	}
+	return append(message, sums...), nil
+}
+
+// encodeYAML marshals v with 2-space indentation, as helm writes provenance files
+func encodeYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// signChart returns the helm compatible provenance file for a chart archive
+func signChart(archive []byte, archiveName string, entity *openpgp.Entity) ([]byte, error) {
+	message, err := provenanceMessage(archive, archiveName)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := entity.SigningKey(time.Now())
+	if !ok {
+		return nil, fmt.Errorf("the key has no valid signing key")
+	}
+
+	var out bytes.Buffer
+	w, err := clearsign.Encode(&out, key.PrivateKey, pgpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(message)); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// runVerify implements `harbor-modifier verify`: check a chart archive
+// against its .prov file and a keyring, like helm verify
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyringPath := flags.String("keyring", "", "Keyring file with the public keys to trust (armored or binary)")
+	provPath := flags.String("prov", "", "Provenance file (default: <chart>.prov)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *keyringPath == "" {
+		return fmt.Errorf("usage: harbor-modifier verify -keyring <file> <chart.tgz>")
+	}
+	chartPath := flags.Arg(0)
+	if *provPath == "" {
+		*provPath = chartPath + ".prov"
+	}
+
+	fmt.Printf("🔏 Verifying %s...\n", chartPath)
+
+	keyring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	archive, err := os.ReadFile(chartPath)
+	if err != nil {
+		return fmt.Errorf("failed to read chart: %w", err)
+	}
+	prov, err := os.ReadFile(*provPath)
+	if err != nil {
+		return fmt.Errorf("failed to read provenance file: %w", err)
+	}
+
+	block, _ := clearsign.Decode(prov)
+	if block == nil {
+		return fmt.Errorf("%s contains no clearsigned message", *provPath)
+	}
+	signer, err := block.VerifySignature(keyring, pgpConfig)
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	chartPart, sumsPart, ok := strings.Cut(string(block.Plaintext), provenanceSeparator)
+	if !ok {
+		return fmt.Errorf("%s: malformed provenance message", *provPath)
+	}
+	var sums provenanceSums
+	if err := yaml.Unmarshal([]byte(sumsPart), &sums); err != nil {
+		return fmt.Errorf("%s: failed to parse checksums: %w", *provPath, err)
+	}
+	name := filepath.Base(chartPath)
+	expected, ok := sums.Files[name]
+	if !ok {
+		return fmt.Errorf("provenance does not contain a SHA for a file named %q", name)
+	}
+	actual := "sha256:" + sha256Hex(archive)
+	if expected != actual {
+		return fmt.Errorf("sha256 sum does not match for %s: %q != %q", name, expected, actual)
+	}
+
+	// The signed Chart.yaml must describe the archive's chart
+	var signed, packaged ChartMetadata
+	if err := yaml.Unmarshal([]byte(chartPart), &signed); err != nil {
+		return fmt.Errorf("%s: failed to parse Chart.yaml: %w", *provPath, err)
+	}
+	files, err := readChartArchive(archive)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(files["Chart.yaml"], &packaged); err != nil {
+		return fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+	if signed.Name != packaged.Name || signed.Version != packaged.Version {
+		return fmt.Errorf("provenance is for %s %s, the archive contains %s %s", signed.Name, signed.Version, packaged.Name, packaged.Version)
+	}
+
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		fmt.Printf("  Signed by:   %s\n", identity.Name)
+	}
+	fmt.Printf("  Fingerprint: %X\n", signer.PrimaryKey.Fingerprint)
+	fmt.Printf("  Chart hash:  %s\n", actual)
+	fmt.Printf("✅ %s %s verified\n", packaged.Name, packaged.Version)
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// newTestEntity generates a signing key (EdDSA, which is fast to generate)
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+	return entity
+}
+
+// writeKeyring writes the public keys of entities as an armored keyring
+func writeKeyring(t *testing.T, path string, entities ...*openpgp.Entity) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range entities {
+		if err := entity.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// clearsignMessage signs an arbitrary provenance message
+func clearsignMessage(t *testing.T, entity *openpgp.Entity, message string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := clearsign.Encode(&out, entity.PrivateKey, pgpConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, message); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer := newTestEntity(t, "Harbor Release")
+	dir := t.TempDir()
+	keyring := filepath.Join(dir, "pubring.asc")
+	writeKeyring(t, keyring, signer)
+	otherKeyring := filepath.Join(dir, "other.asc")
+	writeKeyring(t, otherKeyring, newTestEntity(t, "Someone Else"))
+
+	archive := chartArchive(t, "harbor", "1.18.0-reliza.2", map[string]string{"values.yaml": "core: {}\n"})
+	chart := filepath.Join(dir, "harbor-1.18.0-reliza.2.tgz")
+	if err := os.WriteFile(chart, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	prov, err := signChart(archive, filepath.Base(chart), signer)
+	if err != nil {
+		t.Fatalf("signChart: %v", err)
+	}
+	if err := os.WriteFile(chart+".prov", prov, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(prov, []byte("version: 1.18.0-reliza.2\n\n...\nfiles:\n  harbor-1.18.0-reliza.2.tgz: sha256:"+sha256Hex(archive))) {
+		t.Errorf("unexpected provenance message:\n%s", prov)
+	}
+
+	if err := runVerify([]string{"-keyring", keyring, chart}); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// A chart with a different version, but the checksum of the signed archive
+	other := chartArchive(t, "harbor", "1.18.0-reliza.1", nil)
+	message, err := provenanceMessage(other, filepath.Base(chart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chartPart, _, _ := strings.Cut(string(message), provenanceSeparator)
+	mismatched := filepath.Join(dir, "mismatched.prov")
+	signed := clearsignMessage(t, signer, chartPart+provenanceSeparator+"files:\n  "+filepath.Base(chart)+": sha256:"+sha256Hex(archive)+"\n")
+	if err := os.WriteFile(mismatched, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := filepath.Join(dir, "tampered", filepath.Base(chart))
+	writeFiles(t, dir, map[string]string{
+		"tampered/" + filepath.Base(chart):           string(archive) + "\x00",
+		"tampered/" + filepath.Base(chart) + ".prov": string(prov),
+		"renamed.tgz":      string(archive),
+		"renamed.tgz.prov": string(prov),
+	})
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"tampered archive", []string{"-keyring", keyring, tampered}, "sha256 sum does not match"},
+		{"wrong keyring", []string{"-keyring", otherKeyring, chart}, "signature verification failed"},
+		{"mismatched chart version", []string{"-keyring", keyring, "-prov", mismatched, chart}, "provenance is for harbor 1.18.0-reliza.1, the archive contains harbor 1.18.0-reliza.2"},
+		{"renamed archive", []string{"-keyring", keyring, filepath.Join(dir, "renamed.tgz")}, `does not contain a SHA for a file named "renamed.tgz"`},
+		{"not clearsigned", []string{"-keyring", keyring, "-prov", keyring, chart}, "contains no clearsigned message"},
+		{"missing keyring", []string{chart}, "usage:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runVerify(tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSigningEntity(t *testing.T) {
+	release := newTestEntity(t, "Harbor Release")
+	nightly := newTestEntity(t, "Harbor Nightly")
+	publicOnly := newTestEntity(t, "Public Only")
+	publicOnly.PrivateKey = nil
+
+	tests := []struct {
+		name    string
+		keyring openpgp.EntityList
+		keyName string
+		want    *openpgp.Entity
+		wantErr string
+	}{
+		{"single key", openpgp.EntityList{release, publicOnly}, "", release, ""},
+		{"several keys", openpgp.EntityList{release, nightly}, "", nil, "2 matching private keys, select one with -key-name"},
+		{"key name", openpgp.EntityList{release, nightly}, "Nightly", nightly, ""},
+		{"key name by email", openpgp.EntityList{release, nightly}, "Release@example.com", release, ""},
+		{"key name without a match", openpgp.EntityList{release, nightly}, "Stable", nil, `no private key matching "Stable"`},
+		{"key name of a public key", openpgp.EntityList{release, publicOnly}, "Public Only", nil, `no private key matching "Public Only"`},
+		{"no private key", openpgp.EntityList{publicOnly}, "", nil, "the keyring contains no private key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := signingEntity(tt.keyring, tt.keyName)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("signingEntity: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("signingEntity = %s, want %s", got.PrimaryIdentity().Name, tt.want.PrimaryIdentity().Name)
+			}
+		})
+	}
+}
+
+func TestSigningEntityEncrypted(t *testing.T) {
+	entity := newTestEntity(t, "Harbor Release")
+	if err := entity.EncryptPrivateKeys([]byte("secret"), nil); err != nil {
+		t.Fatal(err)
+	}
+	keyring := openpgp.EntityList{entity}
+
+	t.Setenv(passphraseEnv, "")
+	if _, err := signingEntity(keyring, ""); err == nil || !strings.Contains(err.Error(), passphraseEnv) {
+		t.Fatalf("expected a passphrase error, got %v", err)
+	}
+	t.Setenv(passphraseEnv, "wrong")
+	if _, err := signingEntity(keyring, ""); err == nil || !strings.Contains(err.Error(), "failed to decrypt") {
+		t.Fatalf("expected a decryption error, got %v", err)
+	}
+	t.Setenv(passphraseEnv, "secret")
+	signer, err := signingEntity(keyring, "")
+	if err != nil {
+		t.Fatalf("signingEntity: %v", err)
+	}
+	if _, err := signChart(chartArchive(t, "harbor", "1.18.0-reliza.1", nil), "harbor-1.18.0-reliza.1.tgz", signer); err != nil {
+		t.Errorf("signChart with the decrypted key: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Conflict markers written by rebase (diff3 style)
+const (
+	conflictStart = "<<<<<<< "
+	conflictBase  = "||||||| "
+	conflictSep   = "======="
+	conflictEnd   = ">>>>>>> "
+)
+
+// runRebase implements `harbor-modifier rebase -to <version>`: three-way
+// merge each template overlay (ours) with the change between its recorded
+// upstream base and the new upstream version (theirs)
+func runRebase(args []string) error {
+	flags := flag.NewFlagSet("rebase", flag.ExitOnError)
+	to := flags.String("to", "", "Upstream chart version to rebase the template overlays onto")
+	source := flags.String("source", defaultSource, "Harbor chart source: Helm repository URL or oci://host/repo/harbor[:tag|@digest]")
+	plainHTTP := flags.Bool("plain-http", false, "Use plain HTTP for oci:// sources")
+	cacheDir := flags.String("cache-dir", defaultCacheDir(), "Upstream chart cache directory (empty disables caching)")
+	offline := flags.Bool("offline", false, "Use only cached upstream charts, fail on cache miss")
+	flags.Parse(args)
+
+	if *to == "" {
+		return fmt.Errorf("rebase needs -to <version>")
+	}
+
+	cfg := &Config{
+		Source:           *source,
+		PlainHTTP:        *plainHTTP,
+		CacheDir:         *cacheDir,
+		Offline:          *offline,
+		ModificationsDir: filepath.Join(mustGetwd(), "modifications"),
+	}
+
+	fmt.Printf("🔀 Rebasing template overlays onto %s %s...\n", chartName, *to)
+
+	overlays, err := listOverlays(cfg.ModificationsDir)
+	if err != nil {
+		return err
+	}
+	bases, err := loadOverlayBases(cfg.ModificationsDir)
+	if err != nil {
+		return err
+	}
+	upstream, err := upstreamChartFiles(cfg, *to)
+	if err != nil {
+		return err
+	}
+
+	baseCharts := map[string]map[string][]byte{*to: upstream}
+	var merged, conflicted, skipped []string
+	for _, file := range overlays {
+		base := bases.lookup(file)
+		if base == nil {
+			fmt.Printf("  ⏭️  %s: no upstream base recorded, skipping\n", file)
+			skipped = append(skipped, file)
+			continue
+		}
+		theirs, ok := upstream["templates/"+file]
+		if !ok {
+			fmt.Printf("  ⚠️  %s: removed upstream in %s, review manually\n", file, *to)
+			skipped = append(skipped, file)
+			continue
+		}
+		if base.SHA256 == sha256Hex(theirs) {
+			fmt.Printf("  ✅ %s: upstream unchanged\n", file)
+			bases.set(file, *to, theirs)
+			continue
+		}
+
+		if baseCharts[base.Version] == nil {
+			files, err := upstreamChartFiles(cfg, base.Version)
+			if err != nil {
+				return fmt.Errorf("%s: failed to get base: %w", file, err)
+			}
+			baseCharts[base.Version] = files
+		}
+		baseContent, ok := baseCharts[base.Version]["templates/"+file]
+		if !ok || sha256Hex(baseContent) != base.SHA256 {
+			return fmt.Errorf("%s: %s %s does not match the recorded base", file, chartName, base.Version)
+		}
+
+		overlayPath := filepath.Join(cfg.ModificationsDir, "template-overlays", filepath.FromSlash(file))
+		ours, err := os.ReadFile(overlayPath)
+		if err != nil {
+			return fmt.Errorf("failed to read overlay %s: %w", file, err)
+		}
+
+		result, conflicts := merge3(string(baseContent), string(ours), string(theirs),
+			"overlay", "upstream "+base.Version, "upstream "+*to)
+		if err := os.WriteFile(overlayPath, []byte(result), 0644); err != nil {
+			return fmt.Errorf("failed to write overlay %s: %w", file, err)
+		}
+		bases.set(file, *to, theirs)
+
+		if conflicts > 0 {
+			fmt.Printf("  ❌ %s: %d conflict(s)\n", file, conflicts)
+			conflicted = append(conflicted, file)
+		} else {
+			fmt.Printf("  🔀 %s: merged upstream changes\n", file)
+			merged = append(merged, file)
+		}
+	}
+
+	if err := bases.save(cfg.ModificationsDir); err != nil {
+		return err
+	}
+
+	fmt.Println("\nSummary:")
+	fmt.Printf("  Merged cleanly: %d\n", len(merged))
+	fmt.Printf("  Conflicts:      %d\n", len(conflicted))
+	fmt.Printf("  Skipped:        %d\n", len(skipped))
+	if len(conflicted) > 0 {
+		fmt.Println("\nResolve the conflict markers in:")
+		for _, file := range conflicted {
+			fmt.Printf("  modifications/template-overlays/%s\n", file)
+		}
+		return fmt.Errorf("%d template overlay(s) need manual merging", len(conflicted))
+	}
+
+	fmt.Printf("\n✅ Template overlays rebased onto %s\n", *to)
+	fmt.Printf("\nNext steps:\n  1. Review: git diff modifications/\n  2. Build:  ./build-local.sh %s\n", *to)
+	return nil
+}
+
+// merge3 merges the changes from base to ours and from base to theirs line
+// by line. Overlapping different changes become conflict blocks with diff3
+// style markers; it returns the result and the number of conflicts.
+func merge3(base, ours, theirs, oursName, baseName, theirsName string) (string, int) {
+	baseLines := splitLines(base)
+	oursLines := splitLines(ours)
+	theirsLines := splitLines(theirs)
+
+	// For each base line, its index in ours/theirs when unchanged (else -1)
+	oursMatch := matchLines(baseLines, oursLines)
+	theirsMatch := matchLines(baseLines, theirsLines)
+
+	var out []string
+	conflicts := 0
+	i, a, b := 0, 0, 0
+	for i < len(baseLines) || a < len(oursLines) || b < len(theirsLines) {
+		if i < len(baseLines) && oursMatch[i] == a && theirsMatch[i] == b {
+			out = append(out, baseLines[i])
+			i, a, b = i+1, a+1, b+1
+			continue
+		}
+
+		// Unstable chunk: up to the next base line unchanged on both sides
+		k := i
+		for k < len(baseLines) && (oursMatch[k] < 0 || theirsMatch[k] < 0) {
+			k++
+		}
+		ea, eb := len(oursLines), len(theirsLines)
+		if k < len(baseLines) {
+			ea, eb = oursMatch[k], theirsMatch[k]
+		}
+
+		baseChunk, oursChunk, theirsChunk := baseLines[i:k], oursLines[a:ea], theirsLines[b:eb]
+		switch {
+		case equalLines(oursChunk, baseChunk):
+			out = append(out, theirsChunk...)
+		case equalLines(theirsChunk, baseChunk), equalLines(oursChunk, theirsChunk):
+			out = append(out, oursChunk...)
+		default:
+			conflicts++
+			out = append(out, conflictStart+oursName)
+			out = append(out, oursChunk...)
+			out = append(out, conflictBase+baseName)
+			out = append(out, baseChunk...)
+			out = append(out, conflictSep)
+			out = append(out, theirsChunk...)
+			out = append(out, conflictEnd+theirsName)
+		}
+		i, a, b = k, ea, eb
+	}
+
+	result := strings.Join(out, "\n")
+	if len(out) > 0 {
+		result += "\n"
+	}
+	return result, conflicts
+}
+
+// matchLines maps each line of a to its index in b when kept by the diff, else -1
+func matchLines(a, b []string) []int {
+	match := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range diffLines(a, b) {
+		switch op.Kind {
+		case ' ':
+			match[i] = j
+			i++
+			j++
+		case '-':
+			match[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return match
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasConflictMarkers reports whether content still contains rebase conflict markers
+func hasConflictMarkers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, conflictStart) || strings.HasPrefix(line, conflictEnd) {
+			return true
+		}
+	}
+	return false
+}
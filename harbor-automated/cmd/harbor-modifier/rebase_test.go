@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	base := "a\nb\nc\nd\n"
+	tests := []struct {
+		name, ours, theirs, want string
+		conflicts                int
+	}{
+		{"no changes", base, base, base, 0},
+		{"only ours", "a\nB\nc\nd\n", base, "a\nB\nc\nd\n", 0},
+		{"only theirs", base, "a\nb\nc\nD\n", "a\nb\nc\nD\n", 0},
+		{"clean merge", "a\nB\nc\nd\n", "a\nb\nc\nD\n", "a\nB\nc\nD\n", 0},
+		{"identical changes", "a\nX\nc\nd\n", "a\nX\nc\nd\n", "a\nX\nc\nd\n", 0},
+		{"identical deletions", "a\nc\nd\n", "a\nc\nd\n", "a\nc\nd\n", 0},
+		{"deletion and distant edit", "a\nc\nd\n", "a\nb\nc\nD\n", "a\nc\nD\n", 0},
+		{"conflicting edits", "a\nB1\nc\nd\n", "a\nB2\nc\nd\n",
+			"a\n<<<<<<< overlay\nB1\n||||||| base\nb\n=======\nB2\n>>>>>>> new\nc\nd\n", 1},
+		{"insertions at the same spot", "a\nb\nours\nc\nd\n", "a\nb\ntheirs\nc\nd\n",
+			"a\nb\n<<<<<<< overlay\nours\n||||||| base\n=======\ntheirs\n>>>>>>> new\nc\nd\n", 1},
+		{"identical insertions", "a\nb\nnew\nc\nd\n", "a\nb\nnew\nc\nd\n", "a\nb\nnew\nc\nd\n", 0},
+		{"two conflicts", "A1\nb\nc\nD1\n", "A2\nb\nc\nD2\n",
+			"<<<<<<< overlay\nA1\n||||||| base\na\n=======\nA2\n>>>>>>> new\nb\nc\n<<<<<<< overlay\nD1\n||||||| base\nd\n=======\nD2\n>>>>>>> new\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := merge3(base, tt.ours, tt.theirs, "overlay", "base", "new")
+			if got != tt.want || conflicts != tt.conflicts {
+				t.Errorf("merge3 = %d conflict(s)\n%s\nwant %d\n%s", conflicts, got, tt.conflicts, tt.want)
+			}
+			if hasConflictMarkers(got) != (tt.conflicts > 0) {
+				t.Errorf("hasConflictMarkers = %v with %d conflict(s)", hasConflictMarkers(got), conflicts)
+			}
+		})
+	}
+}
+
+func TestMatchLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []int
+	}{
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, []int{0, 1}},
+		{"insertion", []string{"a", "b"}, []string{"a", "x", "b"}, []int{0, 2}},
+		{"deletion", []string{"a", "b", "c"}, []string{"a", "c"}, []int{0, -1, 1}},
+		{"replacement", []string{"a", "b", "c"}, []string{"a", "x", "c"}, []int{0, -1, 2}},
+		{"empty b", []string{"a"}, nil, []int{-1}},
+		{"empty a", nil, []string{"a"}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchLines(tt.a, tt.b)
+			if len(got) != len(tt.want) {
+				t.Fatalf("matchLines = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("matchLines = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRebase(t *testing.T) {
+	repo := newFakeHelmRepo(t)
+	repo.publish("harbor", "1.18.0", chartArchive(t, "harbor", "1.18.0", map[string]string{
+		"templates/core/cm.yaml":  "kind: ConfigMap\nport: 8080\nname: core\nlog: info\n",
+		"templates/core/svc.yaml": "kind: Service\nport: 80\n",
+		"templates/nginx/cm.yaml": "kind: ConfigMap\nworkers: 1\n",
+		"templates/exporter.yaml": "kind: Deployment\n",
+	}), "")
+	repo.publish("harbor", "1.19.0", chartArchive(t, "harbor", "1.19.0", map[string]string{
+		"templates/core/cm.yaml":  "kind: ConfigMap\nport: 8080\nname: core\nlog: debug\n",
+		"templates/core/svc.yaml": "kind: Service\nport: 8443\n",
+		"templates/nginx/cm.yaml": "kind: ConfigMap\nworkers: 1\n",
+	}), "")
+
+	dir := t.TempDir()
+	modDir := filepath.Join(dir, "modifications")
+	overlays := map[string]string{
+		"core/cm.yaml":    "kind: ConfigMap\nport: 9090\nname: core\nlog: info\n",
+		"core/svc.yaml":   "kind: Service\nport: 443\n",
+		"nginx/cm.yaml":   "kind: ConfigMap\nworkers: 4\n",
+		"exporter.yaml":   "kind: Deployment\nreplicas: 2\n",
+		"unrecorded.yaml": "kind: Secret\n",
+	}
+	files := make(map[string]string)
+	for file, content := range overlays {
+		files["modifications/template-overlays/"+file] = content
+	}
+	writeFiles(t, dir, files)
+
+	bases := &OverlayBases{}
+	bases.set("core/cm.yaml", "1.18.0", []byte("kind: ConfigMap\nport: 8080\nname: core\nlog: info\n"))
+	bases.set("core/svc.yaml", "1.18.0", []byte("kind: Service\nport: 80\n"))
+	bases.set("nginx/cm.yaml", "1.18.0", []byte("kind: ConfigMap\nworkers: 1\n"))
+	bases.set("exporter.yaml", "1.18.0", []byte("kind: Deployment\n"))
+	if err := bases.save(modDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	err := runRebase([]string{"-to", "1.19.0", "-source", repo.URL, "-cache-dir", ""})
+	if err == nil || !strings.Contains(err.Error(), "1 template overlay(s) need manual merging") {
+		t.Fatalf("expected one conflicting overlay, got %v", err)
+	}
+
+	read := func(file string) string {
+		content, err := os.ReadFile(filepath.Join(modDir, "template-overlays", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+	if got := read("core/cm.yaml"); got != "kind: ConfigMap\nport: 9090\nname: core\nlog: debug\n" {
+		t.Errorf("core/cm.yaml not merged cleanly:\n%s", got)
+	}
+	if got := read("core/svc.yaml"); !strings.Contains(got, "<<<<<<< overlay\nport: 443\n||||||| upstream 1.18.0\nport: 80\n=======\nport: 8443\n>>>>>>> upstream 1.19.0\n") {
+		t.Errorf("core/svc.yaml has no conflict block:\n%s", got)
+	}
+	for file, content := range map[string]string{"nginx/cm.yaml": overlays["nginx/cm.yaml"], "exporter.yaml": overlays["exporter.yaml"], "unrecorded.yaml": overlays["unrecorded.yaml"]} {
+		if got := read(file); got != content {
+			t.Errorf("%s changed:\n%s", file, got)
+		}
+	}
+
+	// Merged and unchanged overlays move to the new base, the rest keep theirs
+	rebased, err := loadOverlayBases(modDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, version := range map[string]string{"core/cm.yaml": "1.19.0", "core/svc.yaml": "1.19.0", "nginx/cm.yaml": "1.19.0", "exporter.yaml": "1.18.0"} {
+		if base := rebased.lookup(file); base == nil || base.Version != version {
+			t.Errorf("%s base = %+v, want version %s", file, base, version)
+		}
+	}
+	if rebased.lookup("unrecorded.yaml") != nil {
+		t.Error("rebase recorded a base for an overlay without one")
+	}
+
+	if err := runRebase(nil); err == nil || !strings.Contains(err.Error(), "needs -to") {
+		t.Errorf("expected a missing -to error, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// recordFile is written to the chart root and lists the modifications that
+// were applied, so re-running on an already modified chart is safe
+const recordFile = ".harbor-modifier.yaml"
+
+// ModificationRecord tracks applied modification steps and the content
+// hashes of their input files
+type ModificationRecord struct {
+	Steps []StepRecord `yaml:"steps"`
+}
+
+// StepRecord is one applied step, e.g. "values" with modifications/values/*
+type StepRecord struct {
+	Name  string     `yaml:"name"`
+	Files []FileHash `yaml:"files"`
+}
+
+// FileHash is a modification file (relative to modifications/) and its SHA-256
+type FileHash struct {
+	File   string `yaml:"file"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// loadRecord reads the modification record from a chart directory. A
+// missing record means nothing has been applied.
+func loadRecord(chartDir string) (*ModificationRecord, error) {
+	content, err := os.ReadFile(filepath.Join(chartDir, recordFile))
+	if os.IsNotExist(err) {
+		return &ModificationRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", recordFile, err)
+	}
+
+	var record ModificationRecord
+	if err := yaml.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", recordFile, err)
+	}
+	return &record, nil
+}
+
+// save writes the modification record to the chart directory
+func (r *ModificationRecord) save(chartDir string) error {
+	content, err := yaml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", recordFile, err)
+	}
+
+	header := "# Generated by harbor-modifier - modifications applied to this chart\n"
+	if err := os.WriteFile(filepath.Join(chartDir, recordFile), append([]byte(header), content...), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", recordFile, err)
+	}
+	return nil
+}
+
+func (r *ModificationRecord) step(name string) *StepRecord {
+	for i := range r.Steps {
+		if r.Steps[i].Name == name {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// apply runs fn unless the step was already applied with identical inputs.
+// name is both the step name and its input path under modifications/.
+func (r *ModificationRecord) apply(cfg *Config, name string, fn func(*Config) error) error {
+	files, err := hashModificationFiles(cfg.ModificationsDir, name)
+	if err != nil {
+		return err
+	}
+
+	if previous := r.step(name); previous != nil {
+		if reflect.DeepEqual(previous.Files, files) {
+			fmt.Printf("  → %s: already applied (unchanged), skipping...\n", name)
+			return nil
+		}
+		return fmt.Errorf("chart already has different %s modifications applied (see %s); rebuild from the upstream chart", name, recordFile)
+	}
+
+	if err := fn(cfg); err != nil {
+		return err
+	}
+
+	r.Steps = append(r.Steps, StepRecord{Name: name, Files: files})
+	return nil
+}
+
+// hashModificationFiles hashes every file at input (a file or directory
+// under modDir) in lexical order
+func hashModificationFiles(modDir, input string) ([]FileHash, error) {
+	files := []FileHash{}
+	root := filepath.Join(modDir, input)
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(modDir, path)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(content)
+		files = append(files, FileHash{File: filepath.ToSlash(relPath), SHA256: hex.EncodeToString(sum[:])})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", input, err)
+	}
+	return files, nil
+}
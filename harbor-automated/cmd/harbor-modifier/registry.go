@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeHelmChartLayer     = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// RegistryClient is a minimal OCI distribution API client supporting
+// anonymous (or basic) bearer token authentication
+type RegistryClient struct {
+	Client    *http.Client
+	PlainHTTP bool // Use http:// instead of https:// (local registries)
+	Username  string
+	Password  string
+
+	mu     sync.Mutex
+	tokens map[string]string // Bearer tokens by host and repository
+}
+
+// ociManifest is the subset of an OCI image manifest or index we need
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// NewRegistryClient creates a registry client
+func NewRegistryClient(plainHTTP bool) *RegistryClient {
+	return &RegistryClient{
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+		PlainHTTP: plainHTTP,
+		tokens:    make(map[string]string),
+	}
+}
+
+// newRegistryClientFromEnv creates a client with the credentials in
+// HELM_REGISTRY_USERNAME and HELM_REGISTRY_PASSWORD, if set
+func newRegistryClientFromEnv(plainHTTP bool) *RegistryClient {
+	client := NewRegistryClient(plainHTTP)
+	client.Username = os.Getenv("HELM_REGISTRY_USERNAME")
+	client.Password = os.Getenv("HELM_REGISTRY_PASSWORD")
+	return client
+}
+
+// GetManifest fetches the manifest for ref (tag or digest) and returns its
+// content, media type and digest
+func (c *RegistryClient) GetManifest(host, repo, ref string, accept ...string) ([]byte, string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url(host, "/v2/"+repo+"/manifests/"+ref), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(accept) == 0 {
+		accept = []string{mediaTypeOCIManifest, mediaTypeOCIIndex, mediaTypeDockerManifest, mediaTypeDockerManifestList}
+	}
+	req.Header.Set("Accept", strings.Join(accept, ", "))
+
+	resp, err := c.do(req, host, repo)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read manifest %s/%s:%s: %w", host, repo, ref, err)
+	}
+
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(ref, "sha256:") && ref != digest {
+		return nil, "", "", fmt.Errorf("manifest digest mismatch for %s/%s: requested %s, got %s", host, repo, ref, digest)
+	}
+
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return body, mediaType, digest, nil
+}
+
+// ManifestDigest resolves ref (tag or digest) to the digest of the manifest
+// the registry serves for it; for multi-arch images that is the index
+// (manifest list) digest
+func (c *RegistryClient) ManifestDigest(host, repo, ref string) (string, error) {
+	req, err := http.NewRequest(http.MethodHead, c.url(host, "/v2/"+repo+"/manifests/"+ref), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join([]string{mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest}, ", "))
+
+	resp, err := c.do(req, host, repo)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); strings.HasPrefix(digest, "sha256:") {
+		return digest, nil
+	}
+
+	// Registries may omit the header: hash the manifest instead
+	_, _, digest, err := c.GetManifest(host, repo, ref, mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest)
+	return digest, err
+}
+
+// GetBlob downloads a blob and verifies its digest
+func (c *RegistryClient) GetBlob(host, repo, digest string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url(host, "/v2/"+repo+"/blobs/"+digest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, host, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := "sha256:" + hex.EncodeToString(sum[:]); got != digest {
+		return nil, fmt.Errorf("blob digest mismatch for %s/%s: expected %s, got %s", host, repo, digest, got)
+	}
+	return data, nil
+}
+
+func (c *RegistryClient) url(host, p string) string {
+	scheme := "https"
+	if c.PlainHTTP {
+		scheme = "http"
+	}
+	return scheme + "://" + host + p
+}
+
+// do sends req, answering a bearer token challenge once if needed
+func (c *RegistryClient) do(req *http.Request, host, repo string) (*http.Response, error) {
+	key := host + "/" + repo
+
+	c.mu.Lock()
+	token := c.tokens[key]
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		token, err := c.fetchToken(challenge, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate to %s: %w", host, err)
+		}
+		c.mu.Lock()
+		c.tokens[key] = token
+		c.mu.Unlock()
+
+		retry := req.Clone(req.Context())
+		retry.Header.Set("Authorization", "Bearer "+token)
+		resp, err = c.Client.Do(retry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return resp, nil
+}
+
+// fetchToken requests a pull token for repo from the realm in a
+// "Bearer realm=...,service=...,scope=..." challenge
+func (c *RegistryClient) fetchToken(challenge, repo string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, part := range strings.Split(challenge[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("auth challenge without realm: %q", challenge)
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm %s: %w", realm, err)
+	}
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repo + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tr.Token != "" {
+		return tr.Token, nil
+	}
+	if tr.AccessToken != "" {
+		return tr.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response contains no token")
+}
+
+// ociReference is a parsed "oci://host/repository[:tag][@digest]" reference
+type ociReference struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseOCIReference parses an oci:// chart reference
+func parseOCIReference(ref string) (*ociReference, error) {
+	rest, ok := strings.CutPrefix(ref, "oci://")
+	if !ok {
+		return nil, fmt.Errorf("not an oci:// reference: %s", ref)
+	}
+
+	r := &ociReference{}
+	if i := strings.Index(rest, "@"); i >= 0 {
+		r.Digest = rest[i+1:]
+		rest = rest[:i]
+		if !strings.HasPrefix(r.Digest, "sha256:") {
+			return nil, fmt.Errorf("unsupported digest in %s", ref)
+		}
+	}
+
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return nil, fmt.Errorf("oci reference needs host and repository: %s", ref)
+	}
+	r.Host = rest[:slash]
+	r.Repository = rest[slash+1:]
+
+	// A tag follows the last ":" after the last "/"
+	if i := strings.LastIndex(r.Repository, ":"); i > strings.LastIndex(r.Repository, "/") {
+		r.Tag = r.Repository[i+1:]
+		r.Repository = r.Repository[:i]
+	}
+	return r, nil
+}
+
+// String formats the reference back into oci:// form
+func (r *ociReference) String() string {
+	s := "oci://" + r.Host + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// dockerHubHost is the registry API host for images without a registry
+const dockerHubHost = "registry-1.docker.io"
+
+// imageReference is a parsed container image reference such as
+// "goharbor/harbor-core:v2.11.0" or "host:5000/repo:tag@sha256:..."
+type imageReference struct {
+	Repository string // As written, without tag and digest
+	Tag        string
+	Digest     string
+}
+
+// parseImageReference parses a container image reference; without tag and
+// digest the tag is "latest"
+func parseImageReference(image string) (*imageReference, error) {
+	if image == "" || strings.ContainsAny(image, " \t\n") {
+		return nil, fmt.Errorf("invalid image reference %q", image)
+	}
+
+	r := &imageReference{Repository: image}
+	if i := strings.Index(r.Repository, "@"); i >= 0 {
+		r.Digest = r.Repository[i+1:]
+		r.Repository = r.Repository[:i]
+		if !strings.HasPrefix(r.Digest, "sha256:") {
+			return nil, fmt.Errorf("unsupported digest in image %s", image)
+		}
+	}
+	if i := strings.LastIndex(r.Repository, ":"); i > strings.LastIndex(r.Repository, "/") {
+		r.Tag = r.Repository[i+1:]
+		r.Repository = r.Repository[:i]
+	}
+	if r.Repository == "" || strings.HasSuffix(r.Repository, "/") {
+		return nil, fmt.Errorf("invalid image reference %q", image)
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// registry returns the registry API host and repository path of the image,
+// applying the Docker Hub defaults ("nginx" is registry-1.docker.io/library/nginx)
+func (r *imageReference) registry() (host, repo string) {
+	host, repo, ok := strings.Cut(r.Repository, "/")
+	if !ok || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, repo = "docker.io", r.Repository
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = dockerHubHost
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+	}
+	return host, repo
+}
+
+// String formats the reference back into repository[:tag][@digest] form
+func (r *imageReference) String() string {
+	s := r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const fakeRegistryToken = "test-token"
+
+// fakeRegistry is an OCI distribution API stand-in that requires a bearer
+// token from its /token realm, like Docker Hub or Harbor
+type fakeRegistry struct {
+	*httptest.Server
+	Username, Password string // Credentials the token realm requires, if set
+	OmitDigestHeader   bool   // Do not send Docker-Content-Digest
+
+	manifests     map[string]fakeManifest // "<repo>@<tag or digest>"
+	blobs         map[string][]byte       // Digest → content
+	tokenRequests int
+	requests      map[string]int // Requests by "<method> <path>"
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	reg := &fakeRegistry{
+		manifests: make(map[string]fakeManifest),
+		blobs:     make(map[string][]byte),
+		requests:  make(map[string]int),
+	}
+	reg.Server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+// host is the registry address as used in references
+func (reg *fakeRegistry) host() string {
+	return strings.TrimPrefix(reg.URL, "http://")
+}
+
+func (reg *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.requests[r.Method+" "+r.URL.Path]++
+
+	if r.URL.Path == "/token" {
+		reg.tokenRequests++
+		if reg.Username != "" {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != reg.Username || pass != reg.Password {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": fakeRegistryToken})
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/v2/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+fakeRegistryToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, reg.URL))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if repo, ref, ok := strings.Cut(path, "/manifests/"); ok {
+		m, ok := reg.manifests[repo+"@"+ref]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		if !reg.OmitDigestHeader {
+			w.Header().Set("Docker-Content-Digest", digestOf(m.content))
+		}
+		if r.Method == http.MethodGet {
+			w.Write(m.content)
+		}
+		return
+	}
+	if _, digest, ok := strings.Cut(path, "/blobs/"); ok {
+		data, ok := reg.blobs[digest]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// addBlob stores a blob and returns its digest
+func (reg *fakeRegistry) addBlob(data []byte) string {
+	digest := digestOf(data)
+	reg.blobs[digest] = data
+	return digest
+}
+
+// addManifest stores a manifest under tag (if not empty) and its digest
+func (reg *fakeRegistry) addManifest(repo, tag, mediaType string, manifest interface{}) string {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err)
+	}
+	digest := digestOf(content)
+	reg.manifests[repo+"@"+digest] = fakeManifest{mediaType: mediaType, content: content}
+	if tag != "" {
+		reg.manifests[repo+"@"+tag] = fakeManifest{mediaType: mediaType, content: content}
+	}
+	return digest
+}
+
+// pushChart stores a chart archive as a Helm OCI artifact and returns the
+// manifest digest
+func (reg *fakeRegistry) pushChart(repo, tag string, archive []byte) string {
+	config := []byte(`{"name":"harbor"}`)
+	return reg.addManifest(repo, tag, mediaTypeOCIManifest, ociManifest{
+		MediaType: mediaTypeOCIManifest,
+		Config:    ociDescriptor{MediaType: "application/vnd.cncf.helm.config.v1+json", Digest: reg.addBlob(config), Size: int64(len(config))},
+		Layers:    []ociDescriptor{{MediaType: mediaTypeHelmChartLayer, Digest: reg.addBlob(archive), Size: int64(len(archive))}},
+	})
+}
+
+func TestRegistryClientTokenFlow(t *testing.T) {
+	reg := newFakeRegistry(t)
+	digest := reg.pushChart("charts/harbor", "1.18.0", []byte("chart"))
+
+	client := NewRegistryClient(true)
+	for i := 0; i < 2; i++ {
+		_, mediaType, got, err := client.GetManifest(reg.host(), "charts/harbor", "1.18.0")
+		if err != nil {
+			t.Fatalf("GetManifest: %v", err)
+		}
+		if got != digest || mediaType != mediaTypeOCIManifest {
+			t.Errorf("manifest = %s %s, want %s %s", got, mediaType, digest, mediaTypeOCIManifest)
+		}
+	}
+	if reg.tokenRequests != 1 {
+		t.Errorf("token requested %d times, want 1 (cached per repository)", reg.tokenRequests)
+	}
+}
+
+func TestRegistryClientCredentials(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.Username, reg.Password = "robot", "secret"
+	reg.pushChart("charts/harbor", "1.18.0", []byte("chart"))
+
+	client := NewRegistryClient(true)
+	if _, _, _, err := client.GetManifest(reg.host(), "charts/harbor", "1.18.0"); err == nil || !strings.Contains(err.Error(), "failed to authenticate") {
+		t.Fatalf("expected an authentication error without credentials, got %v", err)
+	}
+
+	client = NewRegistryClient(true)
+	client.Username, client.Password = "robot", "secret"
+	if _, _, _, err := client.GetManifest(reg.host(), "charts/harbor", "1.18.0"); err != nil {
+		t.Fatalf("GetManifest with credentials: %v", err)
+	}
+}
+
+func TestRegistryClientDigestChecks(t *testing.T) {
+	reg := newFakeRegistry(t)
+	digest := reg.pushChart("charts/harbor", "1.18.0", []byte("chart"))
+	client := NewRegistryClient(true)
+
+	// A manifest requested by digest must hash to that digest
+	other := reg.addManifest("charts/harbor", "", mediaTypeOCIManifest, ociManifest{MediaType: mediaTypeOCIManifest})
+	reg.manifests["charts/harbor@"+other] = reg.manifests["charts/harbor@"+digest]
+	if _, _, _, err := client.GetManifest(reg.host(), "charts/harbor", other); err == nil || !strings.Contains(err.Error(), "manifest digest mismatch") {
+		t.Fatalf("expected a manifest digest mismatch, got %v", err)
+	}
+
+	blob := reg.addBlob([]byte("layer"))
+	reg.blobs[blob] = []byte("tampered")
+	if _, err := client.GetBlob(reg.host(), "charts/harbor", blob); err == nil || !strings.Contains(err.Error(), "blob digest mismatch") {
+		t.Fatalf("expected a blob digest mismatch, got %v", err)
+	}
+}
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    ociReference
+		wantErr bool
+	}{
+		{ref: "oci://registry.example.com/charts/harbor", want: ociReference{Host: "registry.example.com", Repository: "charts/harbor"}},
+		{ref: "oci://localhost:5000/harbor:1.18.0", want: ociReference{Host: "localhost:5000", Repository: "harbor", Tag: "1.18.0"}},
+		{ref: "oci://r.example.com/harbor:1.18.0@sha256:abc", want: ociReference{Host: "r.example.com", Repository: "harbor", Tag: "1.18.0", Digest: "sha256:abc"}},
+		{ref: "https://helm.goharbor.io", wantErr: true},
+		{ref: "oci://registry.example.com", wantErr: true},
+		{ref: "oci://r.example.com/harbor@md5:abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOCIReference(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseOCIReference(%s): expected an error", tt.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseOCIReference(%s): %v", tt.ref, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseOCIReference(%s) = %+v, want %+v", tt.ref, *got, tt.want)
+		}
+		if got.String() != tt.ref {
+			t.Errorf("String() = %s, want %s", got.String(), tt.ref)
+		}
+	}
+}
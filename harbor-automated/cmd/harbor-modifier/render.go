@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultKubeVersion is what .Capabilities.KubeVersion reports when rendering
+	defaultKubeVersion = "v1.31.0"
+	// maxIncludeDepth guards against recursive include/tpl calls, as in Helm
+	maxIncludeDepth = 1000
+)
+
+// defaultAPIVersions are the API groups .Capabilities.APIVersions.Has
+// reports as available (built-in Kubernetes APIs only, like helm template)
+var defaultAPIVersions = []string{
+	"v1",
+	"apps/v1",
+	"batch/v1",
+	"autoscaling/v1",
+	"autoscaling/v2",
+	"policy/v1",
+	"networking.k8s.io/v1",
+	"rbac.authorization.k8s.io/v1",
+	"storage.k8s.io/v1",
+	"apiextensions.k8s.io/v1",
+	"admissionregistration.k8s.io/v1",
+	"coordination.k8s.io/v1",
+	"discovery.k8s.io/v1",
+	"scheduling.k8s.io/v1",
+	"certificates.k8s.io/v1",
+}
+
+// Release is .Release for templates
+type Release struct {
+	Name      string
+	Namespace string
+	Revision  int
+	IsInstall bool
+	IsUpgrade bool
+	Service   string
+}
+
+// Capabilities is .Capabilities for templates
+type Capabilities struct {
+	KubeVersion KubeVersion
+	APIVersions APIVersions
+	HelmVersion map[string]string
+}
+
+// KubeVersion is .Capabilities.KubeVersion
+type KubeVersion struct {
+	Version    string
+	Major      string
+	Minor      string
+	GitVersion string
+}
+
+func (v KubeVersion) String() string {
+	return v.Version
+}
+
+// APIVersions is .Capabilities.APIVersions
+type APIVersions []string
+
+// Has reports whether an API version (or version/Kind) is available
+func (a APIVersions) Has(version string) bool {
+	for _, v := range a {
+		if v == version || strings.HasPrefix(version, v+"/") && !strings.Contains(strings.TrimPrefix(version, v+"/"), "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ChartFiles is .Files for templates
+type ChartFiles map[string][]byte
+
+// Get returns a file's content as a string ("" if missing)
+func (f ChartFiles) Get(name string) string {
+	return string(f[name])
+}
+
+// GetBytes returns a file's content
+func (f ChartFiles) GetBytes(name string) []byte {
+	return f[name]
+}
+
+// Glob returns the files matching pattern
+func (f ChartFiles) Glob(pattern string) ChartFiles {
+	out := make(ChartFiles)
+	for name, content := range f {
+		if ok, _ := path.Match(pattern, name); ok {
+			out[name] = content
+		}
+	}
+	return out
+}
+
+// Lines returns a file's lines
+func (f ChartFiles) Lines(name string) []string {
+	content := strings.TrimSuffix(string(f[name]), "\n")
+	if content == "" {
+		return nil
+	}
+	return strings.Split(content, "\n")
+}
+
+// AsConfig renders the files as ConfigMap data
+func (f ChartFiles) AsConfig() string {
+	data := make(map[string]string, len(f))
+	for name, content := range f {
+		data[path.Base(name)] = string(content)
+	}
+	return toYAML(data)
+}
+
+// AsSecrets renders the files as base64 Secret data
+func (f ChartFiles) AsSecrets() string {
+	data := make(map[string]string, len(f))
+	for name, content := range f {
+		data[path.Base(name)] = base64.StdEncoding.EncodeToString(content)
+	}
+	return toYAML(data)
+}
+
+// Renderer renders a chart with text/template and the Helm function set,
+// like helm template but without the helm binary or a cluster. lookup
+// always returns an empty result.
+type Renderer struct {
+	Release      Release
+	Capabilities Capabilities
+}
+
+// NewRenderer returns a renderer for an install of release into namespace
+func NewRenderer(release, namespace string) *Renderer {
+	major, minor := "1", "31"
+	if parts := strings.Split(strings.TrimPrefix(defaultKubeVersion, "v"), "."); len(parts) >= 2 {
+		major, minor = parts[0], parts[1]
+	}
+	return &Renderer{
+		Release: Release{Name: release, Namespace: namespace, Revision: 1, IsInstall: true, Service: "Helm"},
+		Capabilities: Capabilities{
+			KubeVersion: KubeVersion{Version: defaultKubeVersion, Major: major, Minor: minor, GitVersion: defaultKubeVersion},
+			APIVersions: APIVersions(defaultAPIVersions),
+			HelmVersion: map[string]string{"version": "v3.16.0"},
+		},
+	}
+}
+
+// renderTemplate is a template to execute with its chart scope
+type renderTemplate struct {
+	name   string // e.g. harbor/templates/core/core-dpl.yaml
+	source string
+	scope  map[string]interface{}
+}
+
+// Render renders every template of chart (and its enabled subcharts) with
+// values merged over the chart defaults. The result maps template names,
+// e.g. harbor/templates/core/core-dpl.yaml, to their rendered content.
+func (r *Renderer) Render(chart *LoadedChart, values map[string]interface{}) (map[string]string, error) {
+	var templates []renderTemplate
+	r.collect(chart, chart.Metadata.Name, coalesceValues(chart.Values, values), &templates)
+	sort.Slice(templates, func(i, j int) bool { return templates[i].name < templates[j].name })
+
+	root := template.New("chart")
+	includeDepth := 0
+
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	for name, fn := range helmFuncs() {
+		funcs[name] = fn
+	}
+	funcs["include"] = func(name string, data interface{}) (string, error) {
+		if includeDepth > maxIncludeDepth {
+			return "", fmt.Errorf("rendering template has a nested reference name: %s: too many include calls", name)
+		}
+		includeDepth++
+		defer func() { includeDepth-- }()
+
+		var buf bytes.Buffer
+		if err := root.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	funcs["tpl"] = func(text string, data interface{}) (string, error) {
+		if includeDepth > maxIncludeDepth {
+			return "", fmt.Errorf("tpl: too many nested calls")
+		}
+		includeDepth++
+		defer func() { includeDepth-- }()
+
+		name := "tpl"
+		if scope, ok := data.(map[string]interface{}); ok {
+			if tmpl, ok := scope["Template"].(map[string]interface{}); ok {
+				name = fmt.Sprint(tmpl["Name"])
+			}
+		}
+		t, err := root.Clone()
+		if err != nil {
+			return "", err
+		}
+		t, err = t.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("tpl: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return strings.ReplaceAll(buf.String(), "<no value>", ""), nil
+	}
+	root.Funcs(funcs).Option("missingkey=zero")
+
+	for _, t := range templates {
+		if _, err := root.New(t.name).Parse(t.source); err != nil {
+			return nil, err
+		}
+	}
+
+	rendered := make(map[string]string)
+	for _, t := range templates {
+		if isPartial(t.name) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := root.ExecuteTemplate(&buf, t.name, t.scope); err != nil {
+			return nil, err
+		}
+		rendered[t.name] = strings.ReplaceAll(buf.String(), "<no value>", "")
+	}
+	return rendered, nil
+}
+
+// collect adds the templates of chart and its enabled subcharts, each with
+// the scope (.Values, .Chart, .Release...) it is executed with
+func (r *Renderer) collect(chart *LoadedChart, prefix string, values map[string]interface{}, out *[]renderTemplate) {
+	for name, source := range chart.Templates {
+		fullName := prefix + "/" + name
+		scope := map[string]interface{}{
+			"Values":       values,
+			"Chart":        chart.Metadata,
+			"Release":      r.Release,
+			"Capabilities": r.Capabilities,
+			"Files":        ChartFiles(chart.Files),
+			"Template": map[string]interface{}{
+				"Name":     fullName,
+				"BasePath": prefix + "/templates",
+			},
+		}
+		*out = append(*out, renderTemplate{name: fullName, source: source, scope: scope})
+	}
+
+	global, _ := values["global"].(map[string]interface{})
+	for _, sub := range chart.Subcharts {
+		dep := chart.dependencyFor(sub)
+		if dep.Condition != "" {
+			if enabled, ok := lookupValue(values, dep.Condition); ok {
+				if b, isBool := enabled.(bool); isBool && !b {
+					continue
+				}
+			}
+		}
+
+		parentValues, _ := values[dep.valuesName()].(map[string]interface{})
+		subValues := coalesceValues(sub.Values, parentValues)
+		if global != nil {
+			subGlobal, _ := subValues["global"].(map[string]interface{})
+			subValues["global"] = coalesceValues(subGlobal, global)
+		}
+		values[dep.valuesName()] = subValues
+		r.collect(sub, prefix+"/charts/"+dep.valuesName(), subValues, out)
+	}
+}
+
+// helmFuncs are the template functions Helm adds on top of sprig
+func helmFuncs() template.FuncMap {
+	return template.FuncMap{
+		"toYaml":        toYAML,
+		"fromYaml":      fromYAML,
+		"fromYamlArray": fromYAMLArray,
+		"toJson":        toJSON,
+		"fromJson":      fromJSON,
+		"fromJsonArray": fromJSONArray,
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if v == nil {
+				return v, fmt.Errorf("%s", msg)
+			}
+			if s, ok := v.(string); ok && s == "" {
+				return v, fmt.Errorf("%s", msg)
+			}
+			return v, nil
+		},
+		// No cluster to query: behave like helm template
+		"lookup": func(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+			return map[string]interface{}{}, nil
+		},
+	}
+}
+
+// toYAML encodes v as YAML without a trailing newline ("" on error, as in Helm)
+func toYAML(v interface{}) string {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return ""
+	}
+	if err := enc.Close(); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func fromYAML(s string) map[string]interface{} {
+	values, err := parseValues([]byte(s))
+	if err != nil {
+		return map[string]interface{}{"Error": err.Error()}
+	}
+	return values
+}
+
+func fromYAMLArray(s string) []interface{} {
+	var list []interface{}
+	if err := yaml.Unmarshal([]byte(s), &list); err != nil {
+		return []interface{}{err.Error()}
+	}
+	return normalizeValue(list).([]interface{})
+}
+
+func toJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func fromJSON(s string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		m["Error"] = err.Error()
+	}
+	return m
+}
+
+func fromJSONArray(s string) []interface{} {
+	var list []interface{}
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return []interface{}{err.Error()}
+	}
+	return list
+}
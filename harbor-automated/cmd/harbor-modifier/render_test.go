@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// renderFixture loads an in-memory chart named harbor from files (Chart.yaml
+// is added) and renders it with values over its defaults
+func renderFixture(t *testing.T, files map[string]string, values map[string]interface{}) (map[string]string, error) {
+	t.Helper()
+	all := map[string][]byte{"Chart.yaml": []byte("apiVersion: v2\nname: harbor\nversion: 1.18.0\nappVersion: 2.14.0\n")}
+	for path, content := range files {
+		all[path] = []byte(content)
+	}
+	chart, err := loadChartFiles(all)
+	if err != nil {
+		t.Fatalf("loadChartFiles: %v", err)
+	}
+	return NewRenderer(renderRelease, renderNamespace).Render(chart, values)
+}
+
+const renderHelpers = `{{- define "harbor.fullname" -}}
+{{ .Release.Name }}-{{ .Chart.Name }}
+{{- end -}}
+
+{{- define "harbor.labels" -}}
+app: {{ include "harbor.fullname" . }}
+release: {{ .Release.Name }}
+{{- end -}}
+`
+
+func TestRenderInclude(t *testing.T) {
+	rendered, err := renderFixture(t, map[string]string{
+		"templates/_helpers.tpl": renderHelpers,
+		"templates/cm.yaml": `metadata:
+  name: {{ include "harbor.fullname" . }}
+  labels:
+    {{- include "harbor.labels" . | nindent 4 }}
+`,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	want := "metadata:\n  name: harbor-harbor\n  labels:\n    app: harbor-harbor\n    release: harbor\n"
+	if got := rendered["harbor/templates/cm.yaml"]; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, ok := rendered["harbor/templates/_helpers.tpl"]; ok {
+		t.Error("partial _helpers.tpl was rendered as a manifest")
+	}
+}
+
+func TestRenderTpl(t *testing.T) {
+	rendered, err := renderFixture(t, map[string]string{
+		"values.yaml":       "externalURL: https://{{ .Release.Name }}.{{ .Values.domain }}\ndomain: example.com\n",
+		"templates/cm.yaml": "url: {{ tpl .Values.externalURL . }}\n",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if got, want := rendered["harbor/templates/cm.yaml"], "url: https://harbor.example.com\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	_, err = renderFixture(t, map[string]string{
+		"values.yaml":       "externalURL: \"{{ .Release.Name \"\n",
+		"templates/cm.yaml": "url: {{ tpl .Values.externalURL . }}\n",
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "tpl:") {
+		t.Fatalf("expected a tpl parse error, got %v", err)
+	}
+}
+
+func TestRenderToYaml(t *testing.T) {
+	rendered, err := renderFixture(t, map[string]string{
+		"values.yaml": `core:
+  podAnnotations:
+    b: "2"
+    a: "1"
+  resources: {}
+`,
+		"templates/dpl.yaml": `annotations:
+  {{- toYaml .Values.core.podAnnotations | nindent 2 }}
+{{- with .Values.core.resources }}
+resources:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+`,
+	}, map[string]interface{}{"core": map[string]interface{}{"podAnnotations": map[string]interface{}{"c": "3"}}})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "annotations:\n  a: \"1\"\n  b: \"2\"\n  c: \"3\"\n"
+	if got := rendered["harbor/templates/dpl.yaml"]; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderRequired(t *testing.T) {
+	files := map[string]string{
+		"templates/secret.yaml": "data:\n  password: {{ required \"database.password is required\" .Values.database.password }}\n",
+	}
+
+	rendered, err := renderFixture(t, files, map[string]interface{}{"database": map[string]interface{}{"password": "s3cr3t"}})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if got := rendered["harbor/templates/secret.yaml"]; !strings.Contains(got, "password: s3cr3t") {
+		t.Errorf("got:\n%s", got)
+	}
+
+	for _, values := range []map[string]interface{}{
+		{"database": map[string]interface{}{}},
+		{"database": map[string]interface{}{"password": ""}},
+	} {
+		_, err := renderFixture(t, files, values)
+		if err == nil || !strings.Contains(err.Error(), "database.password is required") {
+			t.Errorf("values %v: expected the required message, got %v", values, err)
+		}
+	}
+}
+
+func TestRenderErrorsReportFileAndLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"execution error", "a: 1\nb: 2\nc: {{ required \"c is required\" .Values.c }}\n", "harbor/templates/core/cm.yaml:3:"},
+		{"parse error", "a: 1\n{{- end }}\nb: 2\n", "harbor/templates/core/cm.yaml:2:"},
+		{"unknown function", "a: 1\nb: {{ lookupSecret \"x\" }}\n", "harbor/templates/core/cm.yaml:2:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderFixture(t, map[string]string{"templates/core/cm.yaml": tt.template}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error at %s, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRenderSubchartValues(t *testing.T) {
+	rendered, err := renderFixture(t, map[string]string{
+		"Chart.yaml": `apiVersion: v2
+name: harbor
+version: 1.18.0
+dependencies:
+  - name: redis
+    version: 1.0.0
+    condition: redis.enabled
+`,
+		"values.yaml":                     "global:\n  registry: mirror.example.com\nredis:\n  enabled: true\n  port: 6380\n",
+		"charts/redis/Chart.yaml":         "apiVersion: v2\nname: redis\nversion: 1.0.0\n",
+		"charts/redis/values.yaml":        "port: 6379\nimage: redis\n",
+		"charts/redis/templates/svc.yaml": "image: {{ .Values.global.registry }}/{{ .Values.image }}\nport: {{ .Values.port }}\n",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "image: mirror.example.com/redis\nport: 6380\n"
+	if got := rendered["harbor/charts/redis/templates/svc.yaml"]; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	rendered, err = renderFixture(t, map[string]string{
+		"Chart.yaml":                      "apiVersion: v2\nname: harbor\nversion: 1.18.0\ndependencies:\n  - name: redis\n    condition: redis.enabled\n",
+		"charts/redis/Chart.yaml":         "apiVersion: v2\nname: redis\nversion: 1.0.0\n",
+		"charts/redis/templates/svc.yaml": "port: 6379\n",
+	}, map[string]interface{}{"redis": map[string]interface{}{"enabled": false}})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if _, ok := rendered["harbor/charts/redis/templates/svc.yaml"]; ok {
+		t.Error("disabled subchart was rendered")
+	}
+}
+
+func TestVerifyRenderingExamples(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{ChartDir: filepath.Join(dir, "chart"), ProjectDir: dir}
+	writeFiles(t, dir, map[string]string{
+		"chart/Chart.yaml":            "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"chart/templates/cm.yaml":     "host: {{ required \"host is required\" .Values.host }}\n",
+		"examples/with-host.yaml":     "host: harbor.example.com\n",
+		"examples/without-host.yaml":  "other: true\n",
+		"examples/README-ignored.txt": "not values\n",
+	})
+
+	if err := verifyRendering(cfg); err == nil || !strings.Contains(err.Error(), "1 of 2 example(s) failed to render") {
+		t.Fatalf("expected one failing example, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "examples", "without-host.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRendering(cfg); err != nil {
+		t.Fatalf("verifyRendering: %v", err)
+	}
+}
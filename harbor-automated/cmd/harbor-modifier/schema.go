@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// generateValuesSchema writes values.schema.json for the merged values.yaml.
+//
+// Every key gets a structural schema. Mappings that come entirely from our
+// values overlays (backup, imageDigests, expose.traefik...) are typed from
+// their defaults and reject unknown keys, so typos fail at install time;
+// upstream and subchart sections stay permissive. Comments in the overlay
+// files become descriptions. An existing upstream schema takes precedence.
+func generateValuesSchema(cfg *Config) error {
+	fmt.Println("  → Generating values.schema.json...")
+
+	doc, err := readYAMLDocument(filepath.Join(cfg.ChartDir, "values.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to parse values.yaml: %w", err)
+	}
+
+	overlay := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if err := mergeOverlayFiles(overlay, filepath.Join(cfg.ModificationsDir, "values")); err != nil {
+		return err
+	}
+
+	subcharts, err := chartDependencyNames(cfg.ChartDir)
+	if err != nil {
+		return err
+	}
+
+	schema := inferSchema(doc.Content[0], overlay, false)
+	schema["$schema"] = schemaDraft
+	schema["title"] = "Values"
+	schema["type"] = "object"
+	props, _ := schema["properties"].(map[string]interface{})
+	for name := range subcharts {
+		// Subcharts validate their own values
+		if p, ok := props[name].(map[string]interface{}); ok {
+			allowAdditionalProperties(p)
+		}
+	}
+
+	schemaFile := filepath.Join(cfg.ChartDir, "values.schema.json")
+	if content, err := os.ReadFile(schemaFile); err == nil {
+		var upstream map[string]interface{}
+		if err := json.Unmarshal(content, &upstream); err != nil {
+			return fmt.Errorf("failed to parse upstream values.schema.json: %w", err)
+		}
+		mergeSchemas(upstream, schema)
+		schema = upstream
+		fmt.Println("    ✅ Merged with upstream schema")
+	}
+
+	content, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal values.schema.json: %w", err)
+	}
+	if err := os.WriteFile(schemaFile, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write values.schema.json: %w", err)
+	}
+
+	fmt.Println("    ✅ values.schema.json generated")
+	return nil
+}
+
+// inferSchema builds a JSON schema for value. overlay is the matching node
+// from the merged values overlays (nil if none); typed is set inside
+// sections owned by the overlays.
+func inferSchema(value, overlay *yaml.Node, typed bool) map[string]interface{} {
+	schema := map[string]interface{}{}
+
+	switch value.Kind {
+	case yaml.MappingNode:
+		owned := overlay != nil && overlay.Kind == yaml.MappingNode && len(value.Content) > 0
+		props := map[string]interface{}{}
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			key := value.Content[i].Value
+
+			var childOverlay *yaml.Node
+			var description string
+			if overlay != nil {
+				for j := 0; j+1 < len(overlay.Content); j += 2 {
+					if overlay.Content[j].Value == key {
+						childOverlay = overlay.Content[j+1]
+						description = commentText(overlay.Content[j], childOverlay)
+					}
+				}
+			}
+			if childOverlay == nil {
+				owned = false
+			}
+
+			// Values set directly by an overlay are typed from their defaults
+			child := inferSchema(value.Content[i+1], childOverlay, typed || childOverlay != nil && childOverlay.Kind != yaml.MappingNode)
+			if description != "" {
+				child["description"] = description
+			}
+			props[key] = child
+		}
+
+		schema["type"] = []string{"object", "null"}
+		if len(props) > 0 {
+			schema["properties"] = props
+		}
+		if owned {
+			schema["additionalProperties"] = false
+		}
+
+	case yaml.SequenceNode:
+		schema["type"] = []string{"array", "null"}
+
+	case yaml.ScalarNode:
+		if t := scalarType(value); typed && t != "" {
+			schema["type"] = []string{t, "null"}
+		}
+	}
+
+	return schema
+}
+
+// scalarType maps a YAML scalar tag to a JSON schema type
+func scalarType(n *yaml.Node) string {
+	if n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	switch n.ShortTag() {
+	case "!!str":
+		return "string"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	}
+	return ""
+}
+
+// commentText turns the comments on an overlay key into a description,
+// preferring the line comment over the last paragraph of the head comment
+func commentText(key, value *yaml.Node) string {
+	comment := value.LineComment
+	if comment == "" {
+		comment = key.LineComment
+	}
+	if comment == "" {
+		paragraphs := strings.Split(strings.TrimSpace(key.HeadComment), "\n\n")
+		comment = paragraphs[len(paragraphs)-1]
+	}
+
+	var lines []string
+	for _, line := range strings.Split(comment, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// allowAdditionalProperties removes unknown-key restrictions from schema
+// and everything below it
+func allowAdditionalProperties(schema map[string]interface{}) {
+	delete(schema, "additionalProperties")
+	props, _ := schema["properties"].(map[string]interface{})
+	for _, p := range props {
+		if child, ok := p.(map[string]interface{}); ok {
+			allowAdditionalProperties(child)
+		}
+	}
+}
+
+// mergeSchemas adds properties from generated that are missing in upstream
+func mergeSchemas(upstream, generated map[string]interface{}) {
+	genProps, _ := generated["properties"].(map[string]interface{})
+	if len(genProps) == 0 {
+		return
+	}
+
+	upProps, ok := upstream["properties"].(map[string]interface{})
+	if !ok {
+		upProps = map[string]interface{}{}
+		upstream["properties"] = upProps
+	}
+
+	for key, gen := range genProps {
+		up, ok := upProps[key].(map[string]interface{})
+		if !ok {
+			upProps[key] = gen
+			continue
+		}
+		if genMap, ok := gen.(map[string]interface{}); ok {
+			mergeSchemas(up, genMap)
+		}
+	}
+}
+
+// chartDependencyNames returns the names (or aliases) of the chart's dependencies
+func chartDependencyNames(chartDir string) (map[string]bool, error) {
+	content, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Chart.yaml: %w", err)
+	}
+
+	var chart struct {
+		Dependencies []struct {
+			Name  string `yaml:"name"`
+			Alias string `yaml:"alias"`
+		} `yaml:"dependencies"`
+	}
+	if err := yaml.Unmarshal(content, &chart); err != nil {
+		return nil, fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for _, dep := range chart.Dependencies {
+		if dep.Alias != "" {
+			names[dep.Alias] = true
+		} else {
+			names[dep.Name] = true
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSchemaFixture creates a chart with upstream values, a postgresql
+// subchart and values overlays adding a backup section
+func writeSchemaFixture(t *testing.T, files map[string]string) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &Config{ChartDir: filepath.Join(dir, "chart"), ModificationsDir: filepath.Join(dir, "modifications")}
+	fixture := map[string]string{
+		"chart/Chart.yaml": "apiVersion: v2\nname: harbor\nversion: 1.18.0\ndependencies:\n  - name: postgresql\n    version: 0.1.3\n",
+		"chart/values.yaml": `expose:
+  type: ingress
+core:
+  replicas: 1
+postgresql:
+  enabled: false
+  auth:
+    username: harbor
+backup:
+  enabled: false
+  s3:
+    bucket: ""
+    region: us-east-1
+  retention: 7
+`,
+		"modifications/values/backup.yaml": `backup:
+  # Enable scheduled backups
+  enabled: false
+  s3:
+    bucket: "" # S3 bucket name (required)
+    region: us-east-1
+  retention: 7
+`,
+		"modifications/values/postgresql.yaml": "postgresql:\n  enabled: false\n  auth:\n    username: harbor\n",
+	}
+	for path, content := range files {
+		fixture[path] = content
+	}
+	writeFiles(t, dir, fixture)
+	return cfg
+}
+
+func readSchema(t *testing.T, cfg *Config) map[string]interface{} {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(cfg.ChartDir, "values.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(content, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// schemaErrors checks values against the properties, additionalProperties
+// and type keywords used by generateValuesSchema, the way helm install does
+func schemaErrors(schema map[string]interface{}, value interface{}, path string) []string {
+	var errs []string
+	if types, ok := schema["type"].([]interface{}); ok {
+		var matched bool
+		for _, typ := range types {
+			matched = matched || jsonType(value, typ.(string))
+		}
+		if !matched {
+			errs = append(errs, fmt.Sprintf("%s: invalid type, expected %v", path, types))
+		}
+	}
+
+	values, ok := value.(map[string]interface{})
+	if !ok {
+		return errs
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	for key, v := range values {
+		child, ok := props[key].(map[string]interface{})
+		if !ok {
+			if schema["additionalProperties"] == false {
+				errs = append(errs, fmt.Sprintf("%s: additional property %s not allowed", path, key))
+			}
+			continue
+		}
+		errs = append(errs, schemaErrors(child, v, path+"."+key)...)
+	}
+	return errs
+}
+
+func jsonType(value interface{}, typ string) bool {
+	switch value.(type) {
+	case nil:
+		return typ == "null"
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case int:
+		return typ == "integer" || typ == "number"
+	case float64:
+		return typ == "number"
+	}
+	return false
+}
+
+func TestGenerateValuesSchema(t *testing.T) {
+	cfg := writeSchemaFixture(t, nil)
+	if err := generateValuesSchema(cfg); err != nil {
+		t.Fatalf("generateValuesSchema: %v", err)
+	}
+	schema := readSchema(t, cfg)
+
+	tests := []struct {
+		name, values string
+		want         string // Substring of the validation errors, "" when valid
+	}{
+		{"defaults", "backup:\n  enabled: true\n  s3:\n    bucket: harbor-backups\n", ""},
+		{"typo in an overlay section", "backup:\n  s3:\n    bukcet: harbor-backups\n", ".backup.s3: additional property bukcet not allowed"},
+		{"typo at the top of an overlay section", "backup:\n  retension: 14\n", ".backup: additional property retension not allowed"},
+		{"wrong type in an overlay section", "backup:\n  retention: a week\n", ".backup.retention: invalid type"},
+		{"upstream sections are permissive", "core:\n  replicas: many\n  extraEnv: [A]\nexpose:\n  clusterIP: {}\n", ""},
+		{"unknown top-level keys are allowed", "global:\n  imageRegistry: registry.example.com\n", ""},
+		{"subchart sections are permissive", "postgresql:\n  auth:\n    password: secret\n  primary:\n    persistence: {}\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseValues([]byte(tt.values))
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := strings.Join(schemaErrors(schema, values, ""), "\n")
+			if tt.want == "" && errs != "" {
+				t.Fatalf("unexpected validation errors:\n%s", errs)
+			}
+			if !strings.Contains(errs, tt.want) {
+				t.Fatalf("expected %q, got:\n%s", tt.want, errs)
+			}
+		})
+	}
+
+	backup := schema["properties"].(map[string]interface{})["backup"].(map[string]interface{})
+	bucket := backup["properties"].(map[string]interface{})["s3"].(map[string]interface{})["properties"].(map[string]interface{})["bucket"].(map[string]interface{})
+	if bucket["description"] != "S3 bucket name (required)" {
+		t.Errorf("bucket description = %v", bucket["description"])
+	}
+}
+
+func TestGenerateValuesSchemaMergesUpstream(t *testing.T) {
+	cfg := writeSchemaFixture(t, map[string]string{
+		"chart/values.schema.json": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "required": ["expose"],
+  "properties": {
+    "expose": {
+      "type": "object",
+      "properties": {"type": {"type": "string", "enum": ["ingress", "clusterIP"]}}
+    }
+  }
+}
+`,
+	})
+	if err := generateValuesSchema(cfg); err != nil {
+		t.Fatalf("generateValuesSchema: %v", err)
+	}
+	schema := readSchema(t, cfg)
+
+	// Upstream rules win, our sections are added
+	if fmt.Sprint(schema["required"]) != "[expose]" {
+		t.Errorf("upstream required dropped: %v", schema["required"])
+	}
+	props := schema["properties"].(map[string]interface{})
+	exposeType := props["expose"].(map[string]interface{})["properties"].(map[string]interface{})["type"].(map[string]interface{})
+	if exposeType["type"] != "string" || exposeType["enum"] == nil {
+		t.Errorf("upstream expose.type replaced: %v", exposeType)
+	}
+	for _, key := range []string{"backup", "core", "postgresql"} {
+		if _, ok := props[key]; !ok {
+			t.Errorf("generated %s schema not merged", key)
+		}
+	}
+
+	values, err := parseValues([]byte("expose:\n  type: route\nbackup:\n  s3:\n    bukcet: x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := strings.Join(schemaErrors(schema, values, ""), "\n")
+	if !strings.Contains(errs, "bukcet not allowed") {
+		t.Errorf("merged schema accepts the typo:\n%s", errs)
+	}
+
+	writeFiles(t, cfg.ChartDir, map[string]string{"values.schema.json": "{"})
+	if err := generateValuesSchema(cfg); err == nil || !strings.Contains(err.Error(), "failed to parse upstream values.schema.json") {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
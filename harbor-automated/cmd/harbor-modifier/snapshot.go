@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// generatedPlaceholder replaces values that change on every render
+// (randAlphaNum passwords, genCA certificates, their checksums...)
+const generatedPlaceholder = "<generated>"
+
+// runSnapshot implements `harbor-modifier snapshot`: render every example,
+// normalize the objects and compare them with the committed golden files
+func runSnapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	chartDir := flags.String("chart", "harbor-helm", "Chart directory to render")
+	snapshotDir := flags.String("dir", filepath.Join("modifications", "tests", "snapshots"), "Golden file directory")
+	update := flags.Bool("update", false, "Rewrite the golden files instead of comparing")
+	flags.Parse(args)
+
+	fmt.Println("📸 Comparing rendered manifests with snapshots...")
+
+	chart, err := loadChartDir(*chartDir)
+	if err != nil {
+		return err
+	}
+	examples, err := exampleValuesFiles(mustGetwd())
+	if err != nil {
+		return err
+	}
+	if len(examples) == 0 {
+		return fmt.Errorf("no examples/*.yaml found")
+	}
+
+	expected := make(map[string]bool)
+	var changed []string
+	for _, example := range examples {
+		name := filepath.Base(example)
+		goldenFile := filepath.Join(*snapshotDir, name)
+		expected[name] = true
+
+		snapshot, err := renderSnapshot(chart, example)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		golden, err := os.ReadFile(goldenFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", goldenFile, err)
+		}
+		if string(golden) == snapshot {
+			fmt.Printf("  ✅ %s\n", name)
+			continue
+		}
+
+		if *update {
+			if err := os.MkdirAll(*snapshotDir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", *snapshotDir, err)
+			}
+			if err := os.WriteFile(goldenFile, []byte(snapshot), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", goldenFile, err)
+			}
+			fmt.Printf("  📝 %s updated\n", name)
+			continue
+		}
+
+		if golden == nil {
+			fmt.Printf("  ❌ %s: no snapshot (run with -update to create it)\n", name)
+		} else {
+			fmt.Printf("  ❌ %s changed:\n", name)
+			fmt.Println(indent(unifiedDiff(goldenFile, "rendered", string(golden), snapshot), "    "))
+		}
+		changed = append(changed, name)
+	}
+
+	// Snapshots of removed examples
+	stale, err := filepath.Glob(filepath.Join(*snapshotDir, "*.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, file := range stale {
+		name := filepath.Base(file)
+		if expected[name] {
+			continue
+		}
+		if *update {
+			if err := os.Remove(file); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", file, err)
+			}
+			fmt.Printf("  🗑️  %s removed (no matching example)\n", name)
+			continue
+		}
+		fmt.Printf("  ❌ %s: snapshot without a matching example\n", name)
+		changed = append(changed, name)
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf("%d snapshot(s) differ: %s (run with -update to accept)", len(changed), strings.Join(changed, ", "))
+	}
+	fmt.Println("✅ Snapshots up to date")
+	return nil
+}
+
+// renderSnapshot renders chart with a values file and returns the
+// normalized objects: sorted by kind, namespace and name, keys sorted, and
+// values that differ between two renders masked
+func renderSnapshot(chart *LoadedChart, valuesFile string) (string, error) {
+	var renders [2][]Manifest
+	for i := range renders {
+		rendered, err := renderWithValuesFile(chart, valuesFile)
+		if err != nil {
+			return "", err
+		}
+		manifests, err := parseManifests(rendered)
+		if err != nil {
+			return "", err
+		}
+		sortManifests(manifests)
+		renders[i] = manifests
+	}
+
+	first, second := renders[0], renders[1]
+	if len(first) != len(second) {
+		return "", fmt.Errorf("rendering is not stable: %d vs %d objects", len(first), len(second))
+	}
+
+	var sb strings.Builder
+	for i, m := range first {
+		obj := maskGenerated(m.Object, second[i].Object)
+		if i > 0 {
+			sb.WriteString("---\n")
+		}
+		fmt.Fprintf(&sb, "# Source: %s\n", m.Source)
+		sb.WriteString(toYAML(obj))
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// sortManifests orders objects by kind, namespace, name and source template
+func sortManifests(manifests []Manifest) {
+	key := func(m Manifest) string {
+		namespace, _ := lookupPointer(m.Object, "/metadata/namespace")
+		return fmt.Sprintf("%s\x00%v\x00%s\x00%s", m.Kind, namespace, m.Name, m.Source)
+	}
+	sort.SliceStable(manifests, func(i, j int) bool { return key(manifests[i]) < key(manifests[j]) })
+}
+
+// maskGenerated returns a with every leaf that differs from b replaced by
+// generatedPlaceholder
+func maskGenerated(a, b interface{}) interface{} {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			return generatedPlaceholder
+		}
+		out := make(map[string]interface{}, len(av))
+		for k, v := range av {
+			out[k] = maskGenerated(v, bv[k])
+		}
+		return out
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(bv) != len(av) {
+			return generatedPlaceholder
+		}
+		out := make([]interface{}, len(av))
+		for i, v := range av {
+			out[i] = maskGenerated(v, bv[i])
+		}
+		return out
+	case string:
+		// Only mask the changing lines of embedded config files
+		bv, ok := b.(string)
+		if ok && av != bv && strings.Contains(av, "\n") {
+			aLines, bLines := strings.Split(av, "\n"), strings.Split(bv, "\n")
+			if len(aLines) == len(bLines) {
+				for i := range aLines {
+					if aLines[i] != bLines[i] {
+						aLines[i] = generatedPlaceholder
+					}
+				}
+				return strings.Join(aLines, "\n")
+			}
+		}
+		if av != bv {
+			return generatedPlaceholder
+		}
+		return av
+	default:
+		if !reflect.DeepEqual(a, b) {
+			return generatedPlaceholder
+		}
+		return a
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSnapshotFixture creates a project with a chart rendering a config
+// map and a secret with a generated password, and one example
+func writeSnapshotFixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"harbor-helm/Chart.yaml":  "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"harbor-helm/values.yaml": "externalURL: https://core.harbor.domain\nlogLevel: info\n",
+		"harbor-helm/templates/core-cm.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: harbor-core
+data:
+  EXT_ENDPOINT: {{ .Values.externalURL | quote }}
+  LOG_LEVEL: {{ .Values.logLevel }}
+  PORT: "8080"
+  WORKERS: "10"
+  TIMEOUT: "30"
+`,
+		"harbor-helm/templates/core-secret.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: harbor-core
+data:
+  secret: {{ randAlphaNum 16 | b64enc | quote }}
+`,
+		"examples/default.yaml": "externalURL: https://harbor.example.com\n",
+	})
+	return dir
+}
+
+func TestRunSnapshot(t *testing.T) {
+	dir := writeSnapshotFixture(t)
+	t.Chdir(dir)
+	golden := filepath.Join("modifications", "tests", "snapshots", "default.yaml")
+
+	out := captureStdout(t, func() {
+		if err := runSnapshot(nil); err == nil || !strings.Contains(err.Error(), "1 snapshot(s) differ: default.yaml") {
+			t.Errorf("expected a missing snapshot, got %v", err)
+		}
+	})
+	if !strings.Contains(out, "default.yaml: no snapshot (run with -update to create it)") {
+		t.Errorf("missing snapshot not reported:\n%s", out)
+	}
+
+	if err := runSnapshot([]string{"-update"}); err != nil {
+		t.Fatalf("snapshot -update: %v", err)
+	}
+	content, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Source: harbor/templates/core-cm.yaml\n", "EXT_ENDPOINT: https://harbor.example.com\n", "secret: " + generatedPlaceholder + "\n"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("snapshot does not contain %q:\n%s", want, content)
+		}
+	}
+	if err := runSnapshot(nil); err != nil {
+		t.Fatalf("unchanged chart: %v", err)
+	}
+
+	// Change a rendered value
+	writeFiles(t, dir, map[string]string{"harbor-helm/values.yaml": "externalURL: https://core.harbor.domain\nlogLevel: debug\n"})
+	out = captureStdout(t, func() {
+		if err := runSnapshot(nil); err == nil || !strings.Contains(err.Error(), "1 snapshot(s) differ: default.yaml (run with -update to accept)") {
+			t.Errorf("expected a changed snapshot, got %v", err)
+		}
+	})
+	wantDiff := `    --- ` + golden + `
+    +++ rendered
+    @@ -2,7 +2,7 @@
+     apiVersion: v1
+     data:
+       EXT_ENDPOINT: https://harbor.example.com
+    -  LOG_LEVEL: info
+    +  LOG_LEVEL: debug
+       PORT: "8080"
+       TIMEOUT: "30"
+       WORKERS: "10"
+`
+	if !strings.Contains(out, "❌ default.yaml changed:\n"+wantDiff) {
+		t.Errorf("unexpected diff:\n%s\nwant:\n%s", out, wantDiff)
+	}
+
+	if err := runSnapshot([]string{"-update"}); err != nil {
+		t.Fatalf("snapshot -update: %v", err)
+	}
+	content, err = os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "LOG_LEVEL: debug\n") {
+		t.Errorf("snapshot not updated:\n%s", content)
+	}
+
+	// A snapshot without an example is stale
+	writeFiles(t, dir, map[string]string{"modifications/tests/snapshots/removed.yaml": "kind: ConfigMap\n"})
+	if err := runSnapshot(nil); err == nil || !strings.Contains(err.Error(), "removed.yaml") {
+		t.Fatalf("expected a stale snapshot, got %v", err)
+	}
+	if err := runSnapshot([]string{"-update"}); err != nil {
+		t.Fatalf("snapshot -update: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join("modifications", "tests", "snapshots", "removed.yaml")); !os.IsNotExist(err) {
+		t.Error("stale snapshot not removed")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	tests := []struct {
+		name, to, want string
+	}{
+		{"equal", from, ""},
+		{"one change", "a\nb\nc\nd\ne\nF\ng\nh\ni\nj\nk\nl\n",
+			"--- old\n+++ new\n@@ -3,7 +3,7 @@\n c\n d\n e\n-f\n+F\n g\n h\n i\n"},
+		{"two hunks", "A\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nL\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n d\n@@ -9,4 +9,4 @@\n i\n j\n k\n-l\n+L\n"},
+		{"close changes share a hunk", "a\nB\nc\nd\ne\nF\ng\nh\ni\nj\nk\nl\n",
+			"--- old\n+++ new\n@@ -1,9 +1,9 @@\n a\n-b\n+B\n c\n d\n e\n-f\n+F\n g\n h\n i\n"},
+		{"insertion", "a\nb\nnew\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n",
+			"--- old\n+++ new\n@@ -1,5 +1,6 @@\n a\n b\n+new\n c\n d\n e\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", from, tt.to); got != tt.want {
+				t.Errorf("unifiedDiff:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
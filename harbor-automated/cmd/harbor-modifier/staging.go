@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Staging builds the chart in a temporary directory next to the output
+// directory, so the output is only replaced once every step has succeeded.
+//
+// Layout while building:
+//
+//	<parent>/.harbor-helm-staging-XXXX/chart      work directory (Config.ChartDir)
+//	<parent>/.harbor-helm-staging-XXXX/previous   old output during Commit
+type Staging struct {
+	Root      string
+	ChartDir  string
+	OutputDir string
+}
+
+// beginStaging creates a staging directory on the same filesystem as outputDir
+func beginStaging(outputDir string) (*Staging, error) {
+	root, err := os.MkdirTemp(filepath.Dir(outputDir), "."+filepath.Base(outputDir)+"-staging-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return &Staging{
+		Root:      root,
+		ChartDir:  filepath.Join(root, "chart"),
+		OutputDir: outputDir,
+	}, nil
+}
+
+// Commit replaces the output directory with the staged chart. If the swap
+// fails, the previous output is restored.
+func (s *Staging) Commit() error {
+	previous := filepath.Join(s.Root, "previous")
+
+	hadOutput := false
+	if _, err := os.Stat(s.OutputDir); err == nil {
+		if err := os.Rename(s.OutputDir, previous); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", s.OutputDir, err)
+		}
+		hadOutput = true
+	}
+
+	if err := os.Rename(s.ChartDir, s.OutputDir); err != nil {
+		if hadOutput {
+			if rerr := os.Rename(previous, s.OutputDir); rerr != nil {
+				return fmt.Errorf("failed to install staged chart: %w (and failed to restore %s from %s: %v)", err, s.OutputDir, previous, rerr)
+			}
+		}
+		return fmt.Errorf("failed to install staged chart: %w", err)
+	}
+
+	if err := os.RemoveAll(s.Root); err != nil {
+		return fmt.Errorf("failed to clean up staging directory: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the staged chart and leaves the output directory untouched
+func (s *Staging) Abort() {
+	if err := os.RemoveAll(s.Root); err != nil {
+		fmt.Printf("⚠️  Failed to remove staging directory %s: %v\n", s.Root, err)
+	}
+}
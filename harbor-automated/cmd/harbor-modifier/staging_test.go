@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTree returns the files under dir keyed by slash path
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// checkNoStaging fails if a staging directory is left next to outputDir
+func checkNoStaging(t *testing.T, outputDir string) {
+	t.Helper()
+	left, err := filepath.Glob(filepath.Join(filepath.Dir(outputDir), ".harbor-helm-staging-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Errorf("staging directories left behind: %v", left)
+	}
+}
+
+func TestStagingFailedStepKeepsOutput(t *testing.T) {
+	dir := t.TempDir()
+	previous := map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: harbor\nversion: 1.17.0-reliza.4\n",
+		"values.yaml":           "core:\n  replicas: 2\n",
+		"templates/core.yaml":   "kind: Deployment\n",
+		"charts/postgresql.tgz": "archive",
+	}
+	writeFiles(t, filepath.Join(dir, "harbor-helm"), previous)
+	writeFiles(t, dir, map[string]string{
+		"upstream/Chart.yaml":                          "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"upstream/templates/core/cm.yaml":              "kind: ConfigMap\n",
+		"modifications/template-overlays/core/cm.yaml": "kind: ConfigMap\ndata: {}\n",
+	})
+
+	cfg := &Config{
+		FromDir:          filepath.Join(dir, "upstream"),
+		OutputDir:        filepath.Join(dir, "harbor-helm"),
+		ModificationsDir: filepath.Join(dir, "modifications"),
+		Strict:           true,
+	}
+	staging, err := beginStaging(cfg.OutputDir)
+	if err != nil {
+		t.Fatalf("beginStaging: %v", err)
+	}
+	if filepath.Dir(staging.Root) != dir {
+		t.Errorf("staging directory %s is not next to the output", staging.Root)
+	}
+	cfg.ChartDir = staging.ChartDir
+
+	// The pull writes into the staging directory, the next step fails
+	if err := pullChart(cfg); err != nil {
+		t.Fatalf("pullChart: %v", err)
+	}
+	if err := checkOverlayDrift(cfg); err == nil {
+		t.Fatal("expected the strict overlay check to fail")
+	}
+	if _, err := os.Stat(filepath.Join(staging.ChartDir, "Chart.yaml")); err != nil {
+		t.Fatalf("chart not pulled into the staging directory: %v", err)
+	}
+	staging.Abort()
+
+	got := readTree(t, cfg.OutputDir)
+	if len(got) != len(previous) {
+		t.Errorf("harbor-helm/ files = %d, want %d", len(got), len(previous))
+	}
+	for path, content := range previous {
+		if got[path] != content {
+			t.Errorf("harbor-helm/%s = %q, want %q", path, got[path], content)
+		}
+	}
+	checkNoStaging(t, cfg.OutputDir)
+}
+
+func TestStagingCommit(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := filepath.Join(dir, "harbor-helm")
+	writeFiles(t, outputDir, map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: harbor\nversion: 1.17.0\n",
+		"templates/old.yaml": "kind: ConfigMap\n",
+	})
+
+	staging, err := beginStaging(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: harbor\nversion: 1.18.0\n",
+		"templates/new.yaml": "kind: Secret\n",
+	}
+	writeFiles(t, staging.ChartDir, staged)
+	if err := staging.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// The output is replaced, not merged: old files are gone
+	got := readTree(t, outputDir)
+	if len(got) != len(staged) || got["Chart.yaml"] != staged["Chart.yaml"] || got["templates/new.yaml"] != staged["templates/new.yaml"] {
+		t.Errorf("harbor-helm/ = %v, want %v", got, staged)
+	}
+	if _, err := os.Stat(staging.Root); !os.IsNotExist(err) {
+		t.Errorf("staging directory %s not removed", staging.Root)
+	}
+	checkNoStaging(t, outputDir)
+
+	// A first build has no previous output
+	firstOutput := filepath.Join(dir, "first", "harbor-helm")
+	writeFiles(t, filepath.Dir(firstOutput), map[string]string{"README.md": "project\n"})
+	staging, err = beginStaging(firstOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, staging.ChartDir, staged)
+	if err := staging.Commit(); err != nil {
+		t.Fatalf("Commit without previous output: %v", err)
+	}
+	if got := readTree(t, firstOutput); len(got) != len(staged) {
+		t.Errorf("harbor-helm/ = %v, want %v", got, staged)
+	}
+	checkNoStaging(t, firstOutput)
+
+	// Nothing staged: the previous output is restored
+	staging, err = beginStaging(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staging.Commit(); err == nil || !strings.Contains(err.Error(), "failed to install staged chart") {
+		t.Fatalf("expected an install error, got %v", err)
+	}
+	if got := readTree(t, outputDir); got["templates/new.yaml"] != staged["templates/new.yaml"] {
+		t.Errorf("previous output not restored: %v", got)
+	}
+	staging.Abort()
+	checkNoStaging(t, outputDir)
+}
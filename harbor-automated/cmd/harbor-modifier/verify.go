@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// renderRelease and renderNamespace are used for verification renders
+	renderRelease   = "harbor"
+	renderNamespace = "harbor"
+)
+
+// runRender renders an already built chart with the example values, without
+// rebuilding it or needing helm
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	chartDir := flags.String("chart", "harbor-helm", "Chart directory to render")
+	flags.Parse(args)
+
+	return verifyRendering(&Config{ChartDir: *chartDir, ProjectDir: mustGetwd()})
+}
+
+// exampleValuesFiles lists examples/*.yaml in the project directory
+func exampleValuesFiles(projectDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(projectDir, "examples", "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list examples: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// renderWithValuesFile renders chart with a values file over its defaults
+func renderWithValuesFile(chart *LoadedChart, valuesFile string) (map[string]string, error) {
+	content, err := os.ReadFile(valuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", valuesFile, err)
+	}
+	values, err := parseValues(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", valuesFile, err)
+	}
+	return NewRenderer(renderRelease, renderNamespace).Render(chart, values)
+}
+
+// verifyRendering renders the chart with every examples/*.yaml values file
+// and reports template errors (with template file and line) per example
+func verifyRendering(cfg *Config) error {
+	fmt.Println("\n🔍 Rendering chart with example values...")
+
+	chart, err := loadChartDir(cfg.ChartDir)
+	if err != nil {
+		return err
+	}
+	if len(chart.Subcharts) < len(chart.Metadata.Dependencies) {
+		fmt.Println("  ⚠️  Some dependencies are missing in charts/, rendering without them")
+	}
+
+	examples, err := exampleValuesFiles(cfg.ProjectDir)
+	if err != nil {
+		return err
+	}
+	if len(examples) == 0 {
+		fmt.Println("  ⏭️  No examples/*.yaml found, skipping")
+		return nil
+	}
+
+	var failures []string
+	for _, example := range examples {
+		name := filepath.Base(example)
+		rendered, err := renderWithValuesFile(chart, example)
+		if err != nil {
+			fmt.Printf("  ❌ %s\n", name)
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		manifests := 0
+		for _, content := range rendered {
+			if strings.TrimSpace(content) != "" {
+				manifests++
+			}
+		}
+		fmt.Printf("  ✅ %s (%d templates rendered)\n", name, manifests)
+	}
+
+	if len(failures) > 0 {
+		fmt.Println("\n❌ Template errors:")
+		for _, f := range failures {
+			fmt.Printf("  - %s\n", f)
+		}
+		return fmt.Errorf("%d of %d example(s) failed to render", len(failures), len(examples))
+	}
+
+	fmt.Println("✅ All examples render")
+	return nil
+}
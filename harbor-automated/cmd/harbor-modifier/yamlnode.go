@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readYAMLDocument parses a YAML file into a document node, keeping
+// comments and key order. An empty file yields an empty mapping.
+func readYAMLDocument(path string) (*yaml.Node, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level is not a mapping")
+	}
+	return &doc, nil
+}
+
+// writeYAMLDocument encodes a document node with 2-space indentation
+func writeYAMLDocument(path string, doc *yaml.Node) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// mappingValue returns the value node for key in a mapping node
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeOverlayFiles merges every values overlay under valuesDir into the
+// mapping dst. Files in subdirectories are merged under the path named by
+// the directories (values/expose/*.yaml → expose).
+func mergeOverlayFiles(dst *yaml.Node, valuesDir string) error {
+	var valueFiles []string
+	err := filepath.WalkDir(valuesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".yaml" {
+			valueFiles = append(valueFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list values: %w", err)
+	}
+
+	for _, vf := range valueFiles {
+		newDoc, err := readYAMLDocument(vf)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", vf, err)
+		}
+		attachDocumentComments(newDoc)
+
+		relPath, err := filepath.Rel(valuesDir, vf)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		target, err := valuesTarget(dst, filepath.Dir(relPath))
+		if err != nil {
+			return fmt.Errorf("failed to merge %s: %w", relPath, err)
+		}
+
+		// Merge (applying !delete, !append, !prepend and !merge:<key> directives)
+		if err := mergeNodes(target, newDoc.Content[0]); err != nil {
+			return fmt.Errorf("failed to merge %s: %w", relPath, err)
+		}
+	}
+	return nil
+}
+
+// valuesTarget returns the mapping at dir (a values/ subdirectory path such
+// as "expose" or "core/image"), creating missing mappings. "." is the root.
+func valuesTarget(root *yaml.Node, dir string) (*yaml.Node, error) {
+	node := root
+	if dir == "." {
+		return node, nil
+	}
+	for _, key := range strings.Split(filepath.ToSlash(dir), "/") {
+		next := mappingValue(node, key)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+		}
+		if next.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a mapping in values.yaml", strings.ReplaceAll(filepath.ToSlash(dir), "/", "."))
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// deleteMappingKey removes key from a mapping node and reports whether it
+// was present. The removed key's head comment moves to the following key.
+func deleteMappingKey(m *yaml.Node, key string) bool {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != key {
+			continue
+		}
+		if i+2 < len(m.Content) && m.Content[i].HeadComment != "" && m.Content[i+2].HeadComment == "" {
+			m.Content[i+2].HeadComment = m.Content[i].HeadComment
+		}
+		m.Content = append(m.Content[:i], m.Content[i+2:]...)
+		return true
+	}
+	return false
+}
+
+// Overlay directives, written as YAML tags on values in modifications/values/*.yaml:
+//
+//	key: !delete            remove key from the merged values
+//	list: !append [...]     add items to the end of an existing list
+//	list: !prepend [...]    add items to the start of an existing list
+//	list: !merge:name [...] merge mapping items whose "name" fields match, append the rest
+const (
+	tagDelete  = "!delete"
+	tagAppend  = "!append"
+	tagPrepend = "!prepend"
+	tagMerge   = "!merge:"
+)
+
+// mergeNodes deep-merges mapping src into mapping dst, applying overlay
+// directives. Existing keys keep their position and comments; new keys are
+// appended with src comments. Other non-mapping values in src replace
+// those in dst.
+func mergeNodes(dst, src *yaml.Node) error {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		srcKey, srcVal := src.Content[i], src.Content[i+1]
+
+		if srcVal.Tag == tagDelete {
+			deleteMappingKey(dst, srcKey.Value)
+			continue
+		}
+
+		dstVal := mappingValue(dst, srcKey.Value)
+		if dstVal == nil {
+			// New key: resolve directives against an empty value
+			merged, err := mergeValue(nil, srcVal, srcKey.Value)
+			if err != nil {
+				return err
+			}
+			dst.Content = append(dst.Content, srcKey, merged)
+			continue
+		}
+
+		merged, err := mergeValue(dstVal, srcVal, srcKey.Value)
+		if err != nil {
+			return err
+		}
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == srcKey.Value {
+				if merged != dstVal && dst.Content[j].HeadComment == "" {
+					dst.Content[j].HeadComment = srcKey.HeadComment
+				}
+				dst.Content[j+1] = merged
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// mergeValue merges a single overlay value into dst (nil if absent) and
+// returns the resulting node
+func mergeValue(dst, src *yaml.Node, path string) (*yaml.Node, error) {
+	switch {
+	case src.Tag == tagAppend || src.Tag == tagPrepend || strings.HasPrefix(src.Tag, tagMerge):
+		if src.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("%s: %s needs a list", path, src.Tag)
+		}
+		items, err := cleanNodes(src.Content, path)
+		if err != nil {
+			return nil, err
+		}
+		list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: src.Style &^ yaml.TaggedStyle, HeadComment: src.HeadComment, LineComment: src.LineComment}
+		if dst != nil {
+			if dst.Kind != yaml.SequenceNode {
+				return nil, fmt.Errorf("%s: %s target is not a list", path, src.Tag)
+			}
+			list = dst
+		}
+
+		switch {
+		case src.Tag == tagAppend:
+			list.Content = append(list.Content, items...)
+		case src.Tag == tagPrepend:
+			list.Content = append(items, list.Content...)
+		default:
+			if err := mergeListByKey(list, items, strings.TrimPrefix(src.Tag, tagMerge), path); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case src.Kind == yaml.MappingNode:
+		if dst == nil || dst.Kind != yaml.MappingNode {
+			empty := *src
+			empty.Tag = "!!map"
+			empty.Style &^= yaml.TaggedStyle
+			empty.Content = nil
+			dst = &empty
+		}
+		if err := mergeNodes(dst, src); err != nil {
+			return nil, fmt.Errorf("%s.%w", path, err)
+		}
+		return dst, nil
+
+	default:
+		clean, err := cleanNode(src, path)
+		if err != nil {
+			return nil, err
+		}
+		// A replaced value keeps its upstream line comment unless the overlay has one
+		if dst != nil && dst.LineComment != "" && clean.LineComment == "" {
+			commented := *clean
+			commented.LineComment = dst.LineComment
+			clean = &commented
+		}
+		return clean, nil
+	}
+}
+
+// mergeListByKey merges mapping items into list, matching on field
+func mergeListByKey(list *yaml.Node, items []*yaml.Node, field, path string) error {
+	if field == "" {
+		return fmt.Errorf("%s: %s needs a key field, e.g. %sname", path, tagMerge, tagMerge)
+	}
+
+	for _, item := range items {
+		key := mappingValue(item, field)
+		if key == nil || key.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s: list item without %q field", path, field)
+		}
+
+		var existing *yaml.Node
+		for _, candidate := range list.Content {
+			if v := mappingValue(candidate, field); v != nil && v.Value == key.Value {
+				existing = candidate
+				break
+			}
+		}
+
+		if existing == nil {
+			list.Content = append(list.Content, item)
+			continue
+		}
+		if err := mergeNodes(existing, item); err != nil {
+			return fmt.Errorf("%s[%s=%s].%w", path, field, key.Value, err)
+		}
+	}
+	return nil
+}
+
+// cleanNode returns src with overlay directives resolved, so no custom
+// tags reach the generated values.yaml
+func cleanNode(src *yaml.Node, path string) (*yaml.Node, error) {
+	switch {
+	case src.Kind == yaml.MappingNode || src.Tag == tagAppend || src.Tag == tagPrepend || strings.HasPrefix(src.Tag, tagMerge):
+		return mergeValue(nil, src, path)
+	case src.Tag == tagDelete:
+		return nil, fmt.Errorf("%s: %s is only valid as a mapping value", path, tagDelete)
+	case src.Kind == yaml.SequenceNode:
+		items, err := cleanNodes(src.Content, path)
+		if err != nil {
+			return nil, err
+		}
+		clean := *src
+		clean.Content = items
+		return &clean, nil
+	default:
+		return src, nil
+	}
+}
+
+func cleanNodes(nodes []*yaml.Node, path string) ([]*yaml.Node, error) {
+	clean := make([]*yaml.Node, 0, len(nodes))
+	for i, n := range nodes {
+		c, err := cleanNode(n, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		clean = append(clean, c)
+	}
+	return clean, nil
+}
+
+// attachDocumentComments moves a document's leading and trailing comments
+// onto its first and last keys, so they travel with those keys when merged
+// into another document
+func attachDocumentComments(doc *yaml.Node) {
+	root := doc.Content[0]
+	if len(root.Content) == 0 {
+		return
+	}
+
+	if doc.HeadComment != "" {
+		first := root.Content[0]
+		first.HeadComment = strings.TrimSpace(doc.HeadComment + "\n\n" + first.HeadComment)
+		doc.HeadComment = ""
+	}
+
+	foot := strings.TrimSpace(doc.FootComment + "\n\n" + root.FootComment)
+	if foot != "" {
+		last := root.Content[len(root.Content)-2]
+		last.FootComment = strings.TrimSpace(last.FootComment + "\n\n" + foot)
+		doc.FootComment = ""
+		root.FootComment = ""
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeOverlayFilesGolden(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"values.yaml": `# Harbor values
+expose:
+  # Set how to expose the service
+  type: ingress
+  tls:
+    enabled: true # Enable TLS
+# The external URL
+externalURL: https://core.harbor.domain
+core:
+  replicas: 1
+  # Extra environment variables
+  extraEnv: []
+# End of values
+`,
+		"values/backup.yaml": `# Reliza: database backups
+backup:
+  # Enable scheduled backups
+  enabled: false
+  schedule: "0 2 * * *" # Daily at 2am
+`,
+		"values/expose/tls.yaml": `tls:
+  enabled: false
+  # Certificate source
+  certSource: auto
+`,
+		"values/core.yaml": `core:
+  replicas: 2
+  # Reliza: keep the core pods apart
+  affinity: {}
+externalURL: https://harbor.example.com
+`,
+	})
+
+	doc, err := readYAMLDocument(filepath.Join(dir, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeOverlayFiles(doc.Content[0], filepath.Join(dir, "values")); err != nil {
+		t.Fatalf("mergeOverlayFiles: %v", err)
+	}
+	out := filepath.Join(dir, "merged.yaml")
+	if err := writeYAMLDocument(out, doc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing keys keep their order and comments (the upstream foot comment
+	// stays with the last upstream key); new keys are appended with the
+	// overlay's comments, including its document head comment
+	want := `# Harbor values
+expose:
+  # Set how to expose the service
+  type: ingress
+  tls:
+    enabled: false # Enable TLS
+    # Certificate source
+    certSource: auto
+# The external URL
+externalURL: https://harbor.example.com
+core:
+  replicas: 2
+  # Extra environment variables
+  extraEnv: []
+  # Reliza: keep the core pods apart
+  affinity: {}
+# End of values
+
+# Reliza: database backups
+backup:
+  # Enable scheduled backups
+  enabled: false
+  schedule: "0 2 * * *" # Daily at 2am
+`
+	if string(got) != want {
+		t.Errorf("merged values.yaml:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// mergeYAML merges overlay into values with mergeNodes and returns the
+// result encoded, or the merge error
+func mergeYAML(t *testing.T, values, overlay string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"values.yaml": values, "overlay.yaml": overlay})
+	dst, err := readYAMLDocument(filepath.Join(dir, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := readYAMLDocument(filepath.Join(dir, "overlay.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeNodes(dst.Content[0], src.Content[0]); err != nil {
+		return "", err
+	}
+	if err := writeYAMLDocument(filepath.Join(dir, "merged.yaml"), dst); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := os.ReadFile(filepath.Join(dir, "merged.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(merged), nil
+}
+
+func TestMergeDirectives(t *testing.T) {
+	values := `core:
+  # Core replicas
+  replicas: 1
+  debug: false
+  extraEnv:
+    - name: A
+      value: "1"
+    - name: B
+      value: "2"
+`
+	tests := []struct {
+		name, overlay, want string
+	}{
+		{"delete", "core:\n  replicas: !delete\n  missing: !delete\n",
+			"core:\n  # Core replicas\n  debug: false\n  extraEnv:\n    - name: A\n      value: \"1\"\n    - name: B\n      value: \"2\"\n"},
+		{"append", "core:\n  extraEnv: !append\n    - name: C\n      value: \"3\"\n",
+			"core:\n  # Core replicas\n  replicas: 1\n  debug: false\n  extraEnv:\n    - name: A\n      value: \"1\"\n    - name: B\n      value: \"2\"\n    - name: C\n      value: \"3\"\n"},
+		{"prepend", "core:\n  extraEnv: !prepend\n    - name: C\n      value: \"3\"\n",
+			"core:\n  # Core replicas\n  replicas: 1\n  debug: false\n  extraEnv:\n    - name: C\n      value: \"3\"\n    - name: A\n      value: \"1\"\n    - name: B\n      value: \"2\"\n"},
+		{"merge by key", "core:\n  extraEnv: !merge:name\n    - name: B\n      value: \"20\"\n    - name: C\n      value: \"3\"\n",
+			"core:\n  # Core replicas\n  replicas: 1\n  debug: false\n  extraEnv:\n    - name: A\n      value: \"1\"\n    - name: B\n      value: \"20\"\n    - name: C\n      value: \"3\"\n"},
+		{"append to a new key", "core:\n  volumes: !append\n    - name: data\n",
+			"core:\n  # Core replicas\n  replicas: 1\n  debug: false\n  extraEnv:\n    - name: A\n      value: \"1\"\n    - name: B\n      value: \"2\"\n  volumes:\n    - name: data\n"},
+		{"plain list replaces", "core:\n  extraEnv:\n    - name: C\n",
+			"core:\n  # Core replicas\n  replicas: 1\n  debug: false\n  extraEnv:\n    - name: C\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeYAML(t, values, tt.overlay)
+			if err != nil {
+				t.Fatalf("mergeNodes: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("merged:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDirectiveErrors(t *testing.T) {
+	values := "core:\n  replicas: 1\n  extraEnv:\n    - name: A\n"
+	tests := []struct {
+		name, overlay, want string
+	}{
+		{"append on a mapping", "core:\n  extraEnv: !append {name: B}\n", "core.extraEnv: !append needs a list"},
+		{"append to a scalar", "core:\n  replicas: !append [2]\n", "core.replicas: !append target is not a list"},
+		{"prepend to a scalar", "core:\n  replicas: !prepend [2]\n", "core.replicas: !prepend target is not a list"},
+		{"merge without a key field", "core:\n  extraEnv: !merge: [{name: B}]\n", "!merge: needs a key field"},
+		{"merge item without the key field", "core:\n  extraEnv: !merge:name [{value: B}]\n", `core.extraEnv: list item without "name" field`},
+		{"merge on a scalar", "core:\n  extraEnv: !merge:name B\n", "core.extraEnv: !merge:name needs a list"},
+		{"delete inside a list", "core:\n  extraEnv:\n    - !delete\n", "core.extraEnv[0]: !delete is only valid as a mapping value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mergeYAML(t, values, tt.overlay)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDeleteMappingKey(t *testing.T) {
+	got, err := mergeYAML(t, "# Core settings\ncore: {}\nportal: {}\n", "core: !delete\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Core settings\nportal: {}\n"; got != want {
+		t.Errorf("head comment not moved to the next key:\n%s", got)
+	}
+
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	if deleteMappingKey(list, "core") || deleteMappingKey(nil, "core") {
+		t.Error("deleteMappingKey removed a key from a non-mapping")
+	}
+}
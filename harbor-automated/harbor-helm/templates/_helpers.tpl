@@ -635,13 +635,13 @@ Reliza customization: Smart image reference builder
 Handles both standard deployments and reliza-cd tag replacement
 
 Usage: {{ include "harbor.imageRef" (dict "repository" .Values.core.image.repository "tag" .Values.core.image.tag) }}
-With digest: {{ include "harbor.imageRef" (dict "repository" .Values.core.image.repository "tag" .Values.core.image.tag "digest" .Values.imageDigests.core) }}
+With digest: {{ include "harbor.imageRef" (dict "repository" .Values.core.image.repository "tag" .Values.core.image.tag "digest" .Values.imageDigests.core.digest) }}
 */}}
 {{- define "harbor.imageRef" -}}
 {{- $repo := .repository -}}
 {{- $tag := .tag -}}
 {{- $digest := .digest | default "" -}}
-{{- if contains ":" $repo -}}
+{{- if regexMatch "[:@][^/]*$" $repo -}}
   {{/* Repository already contains tag/digest (reliza-cd format), use as-is */}}
   {{- $repo -}}
 {{- else -}}